@@ -0,0 +1,359 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/assignment"
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"github.com/Roy19/distributed-transaction-2pc/sweeper"
+	"gopkg.in/yaml.v3"
+)
+
+// Service describes what a service reads from its configuration and the
+// defaults it runs with.
+type Service struct {
+	Name string
+	// EnvPrefix prefixes the PORT and DSN variables of the service, such as
+	// STORE for STORE_PORT and STORE_DSN.
+	EnvPrefix   string
+	DefaultPort int
+	// Peers maps the name of every service this one calls to its default
+	// base URL.
+	Peers map[string]string
+}
+
+// Config is the configuration of a service. Every value is read, in
+// increasing order of precedence, from the defaults of the Service, the
+// optional configuration file, the environment and the command line flags.
+type Config struct {
+	Port int    `json:"port" yaml:"port"`
+	DSN  string `json:"dsn" yaml:"dsn"`
+	// Peers maps the name of every service this one calls to its base URL.
+	Peers map[string]string `json:"peers" yaml:"peers"`
+	// Seed applies the fixtures when the service starts.
+	Seed bool `json:"seed" yaml:"seed"`
+	// Fixtures is the fixture file applied by Seed and the seed command.
+	Fixtures string `json:"fixtures" yaml:"fixtures"`
+	// ReservationTTL is how long store-svc and delivery-svc hold a
+	// reservation that is not booked.
+	ReservationTTL time.Duration `json:"-" yaml:"-"`
+	// PhaseTimeout bounds every call of order-svc to a participant.
+	PhaseTimeout time.Duration `json:"-" yaml:"-"`
+	// RecoverAfter is how long a transaction goes without progress before
+	// order-svc recovers it, RecoveryInterval how often it looks for one.
+	RecoverAfter     time.Duration `json:"-" yaml:"-"`
+	RecoveryInterval time.Duration `json:"-" yaml:"-"`
+	// TransactionMode is the mode order-svc places an order in when the
+	// request does not pick one.
+	TransactionMode string `json:"-" yaml:"-"`
+	// ClientTimingEvents records DNS, connect and TLS timing on the spans of
+	// calls from order-svc.
+	ClientTimingEvents bool `json:"-" yaml:"-"`
+	// MigrateOnStart applies the pending migrations when the service starts.
+	MigrateOnStart bool `json:"-" yaml:"-"`
+	// AssignmentStrategy is how delivery-svc picks an agent.
+	AssignmentStrategy string `json:"-" yaml:"-"`
+	// SweepInterval is how often expired reservations are released.
+	SweepInterval time.Duration `json:"-" yaml:"-"`
+	// IdempotencyRetention is how long an Idempotency-Key is kept,
+	// IdempotencyLease how long a running request holds it.
+	IdempotencyRetention time.Duration `json:"-" yaml:"-"`
+	IdempotencyLease     time.Duration `json:"-" yaml:"-"`
+	// DBTraceStatements is how SQL statements are recorded on spans, one of
+	// the db.Statement* modes.
+	DBTraceStatements string `json:"-" yaml:"-"`
+	// Args are the command line arguments left after the flags.
+	Args []string `json:"-" yaml:"-"`
+}
+
+// Addr is the address the service listens on.
+func (c *Config) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// Peer returns the base URL of the peer called name.
+func (c *Config) Peer(name string) string {
+	return c.Peers[name]
+}
+
+// peerEnv is the variable holding the URL of a peer, such as STORE_SVC_URL
+// for store-svc.
+func peerEnv(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_URL"
+}
+
+const (
+	// DefaultFixtures is the fixture file used when none is configured.
+	DefaultFixtures         = "fixtures.yaml"
+	DefaultReservationTTL   = 2 * time.Minute
+	DefaultPhaseTimeout     = 10 * time.Second
+	DefaultRecoveryInterval = 30 * time.Second
+)
+
+// MaxRecoveryTime is how long a reservation may wait to be booked: a
+// prepare phase, the time before an unfinished transaction is recovered and
+// a commit phase.
+func (c *Config) MaxRecoveryTime() time.Duration {
+	return 2*c.PhaseTimeout + c.RecoverAfter + c.RecoveryInterval
+}
+
+// durationEnv parses the environment variable name into d when it is set.
+func durationEnv(name string, d *time.Duration) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("%s must be a duration such as 30s, got %q", name, value)
+	}
+	*d = parsed
+	return nil
+}
+
+// boolEnv parses the environment variable name into b when it is set.
+func boolEnv(name string, b *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false, got %q", name, value)
+	}
+	*b = parsed
+	return nil
+}
+
+// Load reads the configuration of service. The configuration file is
+// given by the -config flag or CONFIG_FILE, its format is picked from its
+// extension, .json or .yaml/.yml. The environment holds <EnvPrefix>_PORT,
+// <EnvPrefix>_DSN, one <PEER>_URL per peer, SEED and FIXTURES_FILE, the
+// flags are -port, -dsn, one -<peer>-url per peer, -seed and -fixtures.
+// The timings shared by every service are only read from the environment,
+// from RESERVATION_TTL, ORDER_PHASE_TIMEOUT, ORDER_RECOVER_AFTER and
+// ORDER_RECOVERY_INTERVAL, as are ORDER_TRANSACTION_MODE,
+// HTTP_CLIENT_TIMING_EVENTS, MIGRATE_ON_START, AGENT_ASSIGNMENT_STRATEGY,
+// SWEEP_INTERVAL, IDEMPOTENCY_RETENTION, IDEMPOTENCY_LEASE and
+// DB_TRACE_STATEMENTS.
+func Load(service Service, args []string) (*Config, error) {
+	cfg := &Config{
+		Port:             service.DefaultPort,
+		Peers:            make(map[string]string),
+		Fixtures:         DefaultFixtures,
+		ReservationTTL:   DefaultReservationTTL,
+		PhaseTimeout:     DefaultPhaseTimeout,
+		RecoveryInterval: DefaultRecoveryInterval,
+		MigrateOnStart:   true,
+		SweepInterval:    sweeper.DefaultInterval,
+
+		IdempotencyRetention: idempotency.DefaultRetention,
+		IdempotencyLease:     idempotency.DefaultLease,
+	}
+	for name, baseURL := range service.Peers {
+		cfg.Peers[name] = baseURL
+	}
+
+	flags := flag.NewFlagSet(service.Name, flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON or YAML configuration file")
+	port := flags.Int("port", 0, "port to listen on")
+	dsn := flags.String("dsn", "", "database connection string")
+	seed := flags.Bool("seed", false, "apply the fixtures on start")
+	fixtures := flags.String("fixtures", "", "path to a JSON or YAML fixture file")
+	peers := make(map[string]*string)
+	for name := range service.Peers {
+		peers[name] = flags.String(name+"-url", "", "base URL of "+name)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := cfg.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if value := os.Getenv(service.EnvPrefix + "_PORT"); value != "" {
+		envPort, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s_PORT must be a number, got %q", service.EnvPrefix, value)
+		}
+		cfg.Port = envPort
+	}
+	if value := os.Getenv(service.EnvPrefix + "_DSN"); value != "" {
+		cfg.DSN = value
+	}
+	for name := range service.Peers {
+		if value := os.Getenv(peerEnv(name)); value != "" {
+			cfg.Peers[name] = value
+		}
+	}
+	if value := os.Getenv("SEED"); value != "" {
+		envSeed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("SEED must be true or false, got %q", value)
+		}
+		cfg.Seed = envSeed
+	}
+	if value := os.Getenv("FIXTURES_FILE"); value != "" {
+		cfg.Fixtures = value
+	}
+	timings := []struct {
+		name string
+		d    *time.Duration
+	}{
+		{"RESERVATION_TTL", &cfg.ReservationTTL},
+		{"ORDER_PHASE_TIMEOUT", &cfg.PhaseTimeout},
+		{"ORDER_RECOVER_AFTER", &cfg.RecoverAfter},
+		{"ORDER_RECOVERY_INTERVAL", &cfg.RecoveryInterval},
+		{"SWEEP_INTERVAL", &cfg.SweepInterval},
+		{"IDEMPOTENCY_RETENTION", &cfg.IdempotencyRetention},
+		{"IDEMPOTENCY_LEASE", &cfg.IdempotencyLease},
+	}
+	for _, timing := range timings {
+		if err := durationEnv(timing.name, timing.d); err != nil {
+			return nil, err
+		}
+	}
+	if err := boolEnv("HTTP_CLIENT_TIMING_EVENTS", &cfg.ClientTimingEvents); err != nil {
+		return nil, err
+	}
+	if err := boolEnv("MIGRATE_ON_START", &cfg.MigrateOnStart); err != nil {
+		return nil, err
+	}
+	cfg.TransactionMode = os.Getenv("ORDER_TRANSACTION_MODE")
+	cfg.AssignmentStrategy = os.Getenv("AGENT_ASSIGNMENT_STRATEGY")
+	cfg.DBTraceStatements = os.Getenv("DB_TRACE_STATEMENTS")
+	if cfg.RecoverAfter == 0 {
+		cfg.RecoverAfter = 2 * cfg.PhaseTimeout
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "dsn":
+			cfg.DSN = *dsn
+		case "seed":
+			cfg.Seed = *seed
+		case "fixtures":
+			cfg.Fixtures = *fixtures
+		}
+	})
+	for name, value := range peers {
+		if *value != "" {
+			cfg.Peers[name] = *value
+		}
+	}
+	cfg.Args = flags.Args()
+
+	if err := cfg.validate(service); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// readFile overrides cfg with the values set in the configuration file.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var fileConfig Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &fileConfig)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &fileConfig)
+	default:
+		return fmt.Errorf("config file %s must be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if fileConfig.Port != 0 {
+		c.Port = fileConfig.Port
+	}
+	if fileConfig.DSN != "" {
+		c.DSN = fileConfig.DSN
+	}
+	for name, baseURL := range fileConfig.Peers {
+		c.Peers[name] = baseURL
+	}
+	if fileConfig.Seed {
+		c.Seed = true
+	}
+	if fileConfig.Fixtures != "" {
+		c.Fixtures = fileConfig.Fixtures
+	}
+	return nil
+}
+
+func (c *Config) validate(service Service) error {
+	var errs []error
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535, got %d", c.Port))
+	}
+	if c.DSN == "" {
+		errs = append(errs, fmt.Errorf("a DSN is required, set %s_DSN or -dsn", service.EnvPrefix))
+	}
+	if c.PhaseTimeout <= 0 || c.RecoverAfter <= 0 || c.RecoveryInterval <= 0 {
+		errs = append(errs, fmt.Errorf(
+			"ORDER_PHASE_TIMEOUT, ORDER_RECOVER_AFTER and ORDER_RECOVERY_INTERVAL must be positive"))
+	}
+	// a reservation released before recovery books it can never be booked
+	if c.ReservationTTL <= c.MaxRecoveryTime() {
+		errs = append(errs, fmt.Errorf("RESERVATION_TTL must be longer than %s, twice "+
+			"ORDER_PHASE_TIMEOUT plus ORDER_RECOVER_AFTER and ORDER_RECOVERY_INTERVAL, got %s",
+			c.MaxRecoveryTime(), c.ReservationTTL))
+	}
+	if c.SweepInterval <= 0 || c.IdempotencyRetention <= 0 || c.IdempotencyLease <= 0 {
+		errs = append(errs, fmt.Errorf(
+			"SWEEP_INTERVAL, IDEMPOTENCY_RETENTION and IDEMPOTENCY_LEASE must be positive"))
+	}
+	switch c.TransactionMode {
+	case "", models.ModeTwoPhaseCommit, models.ModeSaga:
+	default:
+		errs = append(errs, fmt.Errorf("ORDER_TRANSACTION_MODE must be %s or %s, got %q",
+			models.ModeTwoPhaseCommit, models.ModeSaga, c.TransactionMode))
+	}
+	if _, err := assignment.New(c.AssignmentStrategy); err != nil {
+		errs = append(errs, fmt.Errorf("AGENT_ASSIGNMENT_STRATEGY: %w", err))
+	}
+	switch c.DBTraceStatements {
+	case "", db.StatementPlaceholders, db.StatementWithValues, db.StatementOmitted:
+	default:
+		errs = append(errs, fmt.Errorf("DB_TRACE_STATEMENTS must be %s, %s or %s, got %q",
+			db.StatementPlaceholders, db.StatementWithValues, db.StatementOmitted, c.DBTraceStatements))
+	}
+	names := make([]string, 0, len(c.Peers))
+	for name := range c.Peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := service.Peers[name]; !ok {
+			errs = append(errs, fmt.Errorf("unknown peer %s", name))
+			continue
+		}
+		parsed, err := url.Parse(c.Peers[name])
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("URL of %s must be an absolute http or https URL, got %q",
+				name, c.Peers[name]))
+		}
+	}
+	return errors.Join(errs...)
+}
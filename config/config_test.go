@@ -0,0 +1,52 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testService = Service{Name: "test-svc", EnvPrefix: "TEST", DefaultPort: 8080}
+
+func TestLoadReadsSettingsFromEnvironment(t *testing.T) {
+	t.Setenv("TEST_DSN", "postgres://localhost/test")
+	t.Setenv("ORDER_TRANSACTION_MODE", "saga")
+	t.Setenv("MIGRATE_ON_START", "false")
+	t.Setenv("SWEEP_INTERVAL", "5s")
+	t.Setenv("DB_TRACE_STATEMENTS", "omit")
+
+	cfg, err := Load(testService, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.TransactionMode != "saga" || cfg.MigrateOnStart || cfg.SweepInterval != 5*time.Second ||
+		cfg.DBTraceStatements != "omit" {
+		t.Errorf("Load read %+v", cfg)
+	}
+}
+
+func TestLoadRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"ORDER_TRANSACTION_MODE", "3pc"},
+		{"HTTP_CLIENT_TIMING_EVENTS", "sometimes"},
+		{"MIGRATE_ON_START", "no thanks"},
+		{"AGENT_ASSIGNMENT_STRATEGY", "random"},
+		{"SWEEP_INTERVAL", "0s"},
+		{"IDEMPOTENCY_RETENTION", "a day"},
+		{"IDEMPOTENCY_LEASE", "-1m"},
+		{"DB_TRACE_STATEMENTS", "all"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_DSN", "postgres://localhost/test")
+			t.Setenv(tt.name, tt.value)
+			_, err := Load(testService, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.name) {
+				t.Errorf("Load with %s=%q returned %v, want an error naming it", tt.name, tt.value, err)
+			}
+		})
+	}
+}
@@ -1,130 +1,27 @@
 package db
 
-import (
-	"log"
-	"sync"
+import "gorm.io/gorm"
 
-	deliveryAgentSvcModels "github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
-	storeSvcModels "github.com/Roy19/distributed-transaction-2pc/store-svc/models"
+var registry = NewRegistry()
 
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
-)
-
-var (
-	dbClients map[string]*gorm.DB
-	dbonce    sync.Once
-)
-
-func InitDB(dsn string, svcName string) {
-	dbonce.Do(func() {
-		dbClients = make(map[string]*gorm.DB)
-		dbClient, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
-		if err != nil {
-			log.Fatalf("Error connecting to database: %v\n", err)
-		}
-		dbClients[svcName] = dbClient
-	})
-}
-
-func GetDBClient(svcName string) *gorm.DB {
-	return dbClients[svcName]
+// InitDB opens the database of svcName in the default registry, statements
+// is one of the Statement* modes of its tracing plugin.
+func InitDB(dsn string, svcName string, pool PoolConfig, statements string) error {
+	return registry.Open(svcName, dsn, pool, statements)
 }
 
-func MigrateModels(svcName string, models ...interface{}) {
-	if dbClients[svcName] != nil {
-		for _, model := range models {
-			dbClients[svcName].AutoMigrate(&model)
-		}
-	}
+// GetDBClient returns the client of svcName from the default registry.
+func GetDBClient(svcName string) (*gorm.DB, error) {
+	return registry.Get(svcName)
 }
 
-func PutDummyDataStoreSvc(svcName string) {
-	if dbClients[svcName] != nil {
-		storeItem := storeSvcModels.StoreItem{
-			Name: "iPhone 12",
-		}
-		dbClients[svcName].Create(&storeItem)
-		storeItemReservations := []storeSvcModels.StoreItemReservation{
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-			{
-				StoreItem:  storeItem,
-				IsReserved: false,
-			},
-		}
-		dbClients[svcName].Create(&storeItemReservations)
-	}
+// Close closes every client of the default registry.
+func Close() error {
+	return registry.Close()
 }
 
-func PutDummyDataDeliveryAgent(svcName string) {
-	if dbClients[svcName] != nil {
-		deliveryAgentReservations := []deliveryAgentSvcModels.DeliveryAgentReservation{
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-			{
-				IsReserved: false,
-			},
-		}
-		dbClients[svcName].Create(&deliveryAgentReservations)
-	}
+// Register adds an already opened client of svcName to the default
+// registry, for clients not opened by InitDB.
+func Register(svcName string, client *gorm.DB) error {
+	return registry.Register(svcName, client)
 }
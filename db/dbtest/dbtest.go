@@ -0,0 +1,277 @@
+// Package dbtest provides a fake database for the tests of code that talks
+// to Postgres through gorm. The fake answers every statement from handlers
+// registered by the test and logs the statements it runs, so that a test
+// can check what was sent without a running database.
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Result is the answer of the fake to a statement. Queries get Columns and
+// Rows, other statements RowsAffected.
+type Result struct {
+	Columns      []string
+	Rows         [][]driver.Value
+	RowsAffected int64
+	Err          error
+}
+
+// Rows answers a query with a single column holding one row per value.
+func Rows(column string, values ...driver.Value) Result {
+	result := Result{Columns: []string{column}}
+	for _, value := range values {
+		result.Rows = append(result.Rows, []driver.Value{value})
+	}
+	return result
+}
+
+// Query is a statement run on the fake, with its whitespace collapsed.
+type Query struct {
+	Statement string
+	Args      []driver.Value
+}
+
+var insertColumns = regexp.MustCompile(`^INSERT INTO "?\w+"? \(([^)]*)\) VALUES`)
+
+// Rows returns the rows of an INSERT statement by column name.
+func (q Query) Rows() []map[string]driver.Value {
+	match := insertColumns.FindStringSubmatch(q.Statement)
+	if match == nil {
+		return nil
+	}
+	columns := strings.Split(match[1], ",")
+	rows := make([]map[string]driver.Value, 0, len(q.Args)/len(columns))
+	for i := 0; i+len(columns) <= len(q.Args); i += len(columns) {
+		row := make(map[string]driver.Value, len(columns))
+		for j, column := range columns {
+			row[strings.Trim(column, `" `)] = q.Args[i+j]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// Handler answers a query.
+type Handler func(query Query) Result
+
+type handler struct {
+	pattern *regexp.Regexp
+	answer  Handler
+}
+
+// Statements logged for the transactions around the other statements.
+const (
+	Begin    = "begin"
+	Commit   = "commit"
+	Rollback = "rollback"
+)
+
+// DB is a fake database. A statement is answered by the last registered
+// handler whose pattern matches it, a statement that no handler matches
+// fails the test.
+type DB struct {
+	t        testing.TB
+	mu       sync.Mutex
+	handlers []handler
+	log      []string
+}
+
+// New returns an empty fake database.
+func New(t testing.TB) *DB {
+	return &DB{t: t}
+}
+
+// Gorm returns a gorm client using the Postgres dialect on the fake.
+func (db *DB) Gorm() *gorm.DB {
+	db.t.Helper()
+	client, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(connector{db})}),
+		&gorm.Config{
+			DisableAutomaticPing: true,
+			Logger:               logger.Discard,
+		})
+	if err != nil {
+		db.t.Fatalf("failed to open the fake database: %v", err)
+	}
+	return client
+}
+
+// On answers with answer the statements matching pattern, a regular
+// expression matched against the statement with its whitespace collapsed.
+func (db *DB) On(pattern string, answer Handler) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.handlers = append(db.handlers, handler{
+		pattern: regexp.MustCompile(pattern),
+		answer:  answer,
+	})
+}
+
+// Log returns every statement run so far, with its whitespace collapsed,
+// along with Begin, Commit and Rollback for the transactions.
+func (db *DB) Log() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.log...)
+}
+
+// Matching returns the logged statements matching pattern.
+func (db *DB) Matching(pattern string) []string {
+	re := regexp.MustCompile(pattern)
+	var matching []string
+	for _, statement := range db.Log() {
+		if re.MatchString(statement) {
+			matching = append(matching, statement)
+		}
+	}
+	return matching
+}
+
+// Reset forgets the logged statements, handlers are kept.
+func (db *DB) Reset() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = nil
+}
+
+func (db *DB) record(entry string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.log = append(db.log, entry)
+}
+
+var whitespace = regexp.MustCompile(`\s+`)
+
+func (db *DB) answer(statement string, args []driver.NamedValue) (result Result) {
+	query := Query{Statement: strings.TrimSpace(whitespace.ReplaceAllString(statement, " "))}
+	db.record(query.Statement)
+	for _, arg := range args {
+		query.Args = append(query.Args, arg.Value)
+	}
+	db.mu.Lock()
+	var answer Handler
+	for i := len(db.handlers) - 1; i >= 0; i-- {
+		if db.handlers[i].pattern.MatchString(query.Statement) {
+			answer = db.handlers[i].answer
+			break
+		}
+	}
+	db.mu.Unlock()
+	if answer == nil {
+		db.t.Errorf("dbtest: unexpected statement %s %v", query.Statement, query.Args)
+		return Result{Err: fmt.Errorf("dbtest: unexpected statement %s", query.Statement)}
+	}
+	// a panicking handler would leave database/sql waiting on the statement
+	defer func() {
+		if r := recover(); r != nil {
+			db.t.Errorf("dbtest: handler of %s panicked: %v", query.Statement, r)
+			result = Result{Err: fmt.Errorf("dbtest: handler of %s panicked", query.Statement)}
+		}
+	}()
+	return answer(query)
+}
+
+type connector struct {
+	db *DB
+}
+
+func (c connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{db: c.db}, nil
+}
+
+func (c connector) Driver() driver.Driver {
+	return fakeDriver{c.db}
+}
+
+type fakeDriver struct {
+	db *DB
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return &conn{db: d.db}, nil
+}
+
+type conn struct {
+	db *DB
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("dbtest: prepared statements are not supported")
+}
+
+func (c *conn) Close() error {
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.record(Begin)
+	return tx{c.db}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	result := c.db.answer(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return driver.RowsAffected(result.RowsAffected), nil
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.answer(query, args)
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	return &rows{columns: result.Columns, values: result.Rows}, nil
+}
+
+type tx struct {
+	db *DB
+}
+
+func (t tx) Commit() error {
+	t.db.record(Commit)
+	return nil
+}
+
+func (t tx) Rollback() error {
+	t.db.record(Rollback)
+	return nil
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string {
+	return r.columns
+}
+
+func (r *rows) Close() error {
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/utils"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// PoolConfig sizes the connection pool of a client. Zero values keep the
+// database/sql defaults.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// PoolConfigFromEnv reads a PoolConfig from DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME.
+func PoolConfigFromEnv() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    utils.IntFromEnv("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:    utils.IntFromEnv("DB_MAX_IDLE_CONNS", 0),
+		ConnMaxLifetime: utils.DurationFromEnv("DB_CONN_MAX_LIFETIME", 0),
+		ConnMaxIdleTime: utils.DurationFromEnv("DB_CONN_MAX_IDLE_TIME", 0),
+	}
+}
+
+// Registry holds named database clients, it is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]*gorm.DB
+}
+
+func NewRegistry() *Registry {
+	return &Registry{clients: make(map[string]*gorm.DB)}
+}
+
+// Open connects to dsn with the tracing plugin recording statements as
+// given by one of the Statement* modes and the pool settings, and registers
+// the client as name.
+func (r *Registry) Open(name string, dsn string, pool PoolConfig, statements string) error {
+	client, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to connect to database %s: %w", name, err)
+	}
+	err = client.Use(&TracingPlugin{Statements: statements})
+	if err == nil {
+		err = applyPool(client, pool)
+	}
+	if err == nil {
+		err = r.Register(name, client)
+	}
+	if err != nil {
+		closeClient(client)
+		return err
+	}
+	return nil
+}
+
+func applyPool(client *gorm.DB, pool PoolConfig) error {
+	sqlDB, err := client.DB()
+	if err != nil {
+		return err
+	}
+	if pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	}
+	if pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	if pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	}
+	if pool.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+	}
+	return nil
+}
+
+// Register adds an already opened client as name.
+func (r *Registry) Register(name string, client *gorm.DB) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[name]; ok {
+		return fmt.Errorf("database %s is already registered", name)
+	}
+	r.clients[name] = client
+	return nil
+}
+
+// Get returns the client registered as name.
+func (r *Registry) Get(name string) (*gorm.DB, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	client, ok := r.clients[name]
+	if !ok {
+		return nil, fmt.Errorf("database %s is not initialized", name)
+	}
+	return client, nil
+}
+
+// Close closes every client and empties the registry.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for name, client := range r.clients {
+		if err := closeClient(client); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close database %s: %w", name, err))
+		}
+		delete(r.clients, name)
+	}
+	return errors.Join(errs...)
+}
+
+func closeClient(client *gorm.DB) error {
+	sqlDB, err := client.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
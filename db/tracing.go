@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/db")
+
+// How statements are recorded in db.statement and db.query.text.
+const (
+	// StatementPlaceholders records the SQL with its parameters left as
+	// placeholders, so no values end up in traces.
+	StatementPlaceholders = "placeholders"
+	// StatementWithValues records the SQL with its parameters filled in.
+	StatementWithValues = "values"
+	// StatementOmitted leaves the statement out.
+	StatementOmitted = "omit"
+)
+
+// dbStatementKey is the attribute that named the statement before the
+// semantic conventions renamed it db.query.text. Both are recorded so that
+// existing dashboards and queries keep working.
+const dbStatementKey = attribute.Key("db.statement")
+
+const (
+	spanKey          = "otel:span"
+	parentContextKey = "otel:parent_context"
+)
+
+// TracingPlugin is a gorm plugin that traces every statement as a child
+// span of the context the statement was run with.
+type TracingPlugin struct {
+	// Statements is one of the Statement* modes, StatementPlaceholders when
+	// empty.
+	Statements string
+}
+
+func (p *TracingPlugin) Name() string {
+	return "otel:tracing"
+}
+
+func (p *TracingPlugin) Initialize(client *gorm.DB) error {
+	callbacks := client.Callback()
+	registrations := []error{
+		callbacks.Create().Before("gorm:create").Register("otel:before_create", p.before("create")),
+		callbacks.Create().After("gorm:create").Register("otel:after_create", p.after("create")),
+		callbacks.Query().Before("gorm:query").Register("otel:before_query", p.before("query")),
+		callbacks.Query().After("gorm:query").Register("otel:after_query", p.after("query")),
+		callbacks.Update().Before("gorm:update").Register("otel:before_update", p.before("update")),
+		callbacks.Update().After("gorm:update").Register("otel:after_update", p.after("update")),
+		callbacks.Delete().Before("gorm:delete").Register("otel:before_delete", p.before("delete")),
+		callbacks.Delete().After("gorm:delete").Register("otel:after_delete", p.after("delete")),
+		callbacks.Row().Before("gorm:row").Register("otel:before_row", p.before("row")),
+		callbacks.Row().After("gorm:row").Register("otel:after_row", p.after("row")),
+		callbacks.Raw().Before("gorm:raw").Register("otel:before_raw", p.before("raw")),
+		callbacks.Raw().After("gorm:raw").Register("otel:after_raw", p.after("raw")),
+	}
+	for _, err := range registrations {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *TracingPlugin) before(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := tracer.Start(tx.Statement.Context, "gorm: "+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL))
+		tx.InstanceSet(parentContextKey, tx.Statement.Context)
+		tx.InstanceSet(spanKey, span)
+		tx.Statement.Context = ctx
+	}
+}
+
+func (p *TracingPlugin) after(callback string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		p.end(tx, callback)
+	}
+}
+
+// end finishes the span started for the statement run by tx.
+func (p *TracingPlugin) end(tx *gorm.DB, callback string) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span, ok := value.(trace.Span)
+	if !ok {
+		return
+	}
+	defer span.End()
+	// later statements on the same session must not nest under this span
+	if parent, ok := tx.InstanceGet(parentContextKey); ok {
+		tx.Statement.Context = parent.(context.Context)
+	}
+
+	sql := tx.Statement.SQL.String()
+	operation := sqlOperation(sql)
+	if operation == "" {
+		operation = strings.ToUpper(callback)
+	}
+	name := operation
+	if tx.Statement.Table != "" {
+		name += " " + tx.Statement.Table
+		span.SetAttributes(semconv.DBCollectionName(tx.Statement.Table))
+	}
+	span.SetName(name)
+	span.SetAttributes(
+		semconv.DBOperationName(operation),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+	switch {
+	case sql == "" || p.Statements == StatementOmitted:
+	case p.Statements == StatementWithValues:
+		text := tx.Dialector.Explain(sql, tx.Statement.Vars...)
+		span.SetAttributes(dbStatementKey.String(text), semconv.DBQueryText(text))
+	default:
+		span.SetAttributes(dbStatementKey.String(sql), semconv.DBQueryText(sql))
+	}
+	if tx.Error != nil && tx.Error != gorm.ErrRecordNotFound {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}
+
+// sqlOperation returns the first keyword of a statement, such as SELECT.
+func sqlOperation(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,143 @@
+package assignment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// RoundRobin picks the agent who has waited longest since their last
+	// assignment.
+	RoundRobin = "round-robin"
+	// LeastLoaded picks the agent with the smallest share of their capacity
+	// in use.
+	LeastLoaded = "least-loaded"
+	// NearestZone picks an agent in the zone of the delivery, or else in the
+	// closest zone, and the least loaded among them.
+	NearestZone = "nearest-zone"
+)
+
+// DefaultStrategy is used when none is configured.
+const DefaultStrategy = NearestZone
+
+// Candidate is a delivery agent on shift with a free reservation.
+type Candidate struct {
+	AgentID  uint
+	Zone     string
+	Capacity int
+	// Load is the number of deliveries the agent holds or has reserved.
+	Load int
+	// LastAssignedAt is zero for an agent who was never assigned.
+	LastAssignedAt time.Time
+}
+
+// Strategy chooses the agent reserved for a delivery.
+type Strategy interface {
+	Name() string
+	// Choose returns the candidate to reserve for a delivery in zone,
+	// candidates is never empty.
+	Choose(zone string, candidates []Candidate) Candidate
+}
+
+// New returns the strategy called name, DefaultStrategy when name is empty.
+func New(name string) (Strategy, error) {
+	switch name {
+	case RoundRobin:
+		return roundRobin{}, nil
+	case LeastLoaded:
+		return leastLoaded{}, nil
+	case NearestZone, "":
+		return nearestZone{}, nil
+	}
+	return nil, fmt.Errorf("unknown assignment strategy %s, use %s, %s or %s",
+		name, RoundRobin, LeastLoaded, NearestZone)
+}
+
+// ZoneDistance is the number of steps between two zones in the tree of
+// regions and zones: 0 for the same zone, 1 from a zone to its region and 2
+// between two zones of the same region. Zones are compared ignoring case.
+func ZoneDistance(a string, b string) int {
+	aPath := zonePath(a)
+	bPath := zonePath(b)
+	common := 0
+	for common < len(aPath) && common < len(bPath) && aPath[common] == bPath[common] {
+		common++
+	}
+	return len(aPath) - common + len(bPath) - common
+}
+
+func zonePath(zone string) []string {
+	var path []string
+	for _, part := range strings.Split(strings.ToLower(zone), "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			path = append(path, part)
+		}
+	}
+	return path
+}
+
+// best returns the first candidate that no other is better than.
+func best(candidates []Candidate, better func(a, b Candidate) bool) Candidate {
+	chosen := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if better(candidate, chosen) {
+			chosen = candidate
+		}
+	}
+	return chosen
+}
+
+func waitedLonger(a, b Candidate) bool {
+	if !a.LastAssignedAt.Equal(b.LastAssignedAt) {
+		return a.LastAssignedAt.Before(b.LastAssignedAt)
+	}
+	return a.AgentID < b.AgentID
+}
+
+// lessLoaded compares the share of capacity in use without dividing.
+func lessLoaded(a, b Candidate) bool {
+	aShare := a.Load * max(b.Capacity, 1)
+	bShare := b.Load * max(a.Capacity, 1)
+	if aShare != bShare {
+		return aShare < bShare
+	}
+	return waitedLonger(a, b)
+}
+
+type roundRobin struct{}
+
+func (roundRobin) Name() string {
+	return RoundRobin
+}
+
+func (roundRobin) Choose(zone string, candidates []Candidate) Candidate {
+	return best(candidates, waitedLonger)
+}
+
+type leastLoaded struct{}
+
+func (leastLoaded) Name() string {
+	return LeastLoaded
+}
+
+func (leastLoaded) Choose(zone string, candidates []Candidate) Candidate {
+	return best(candidates, lessLoaded)
+}
+
+type nearestZone struct{}
+
+func (nearestZone) Name() string {
+	return NearestZone
+}
+
+func (nearestZone) Choose(zone string, candidates []Candidate) Candidate {
+	return best(candidates, func(a, b Candidate) bool {
+		aDistance := ZoneDistance(zone, a.Zone)
+		bDistance := ZoneDistance(zone, b.Zone)
+		if aDistance != bDistance {
+			return aDistance < bDistance
+		}
+		return lessLoaded(a, b)
+	})
+}
@@ -0,0 +1,182 @@
+package assignment
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "", want: DefaultStrategy},
+		{name: RoundRobin, want: RoundRobin},
+		{name: LeastLoaded, want: LeastLoaded},
+		{name: NearestZone, want: NearestZone},
+		{name: "random", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := New(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("New(%q) = %s, want an error", tt.name, strategy.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("New(%q) failed: %v", tt.name, err)
+			}
+			if strategy.Name() != tt.want {
+				t.Errorf("New(%q) = %s, want %s", tt.name, strategy.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestZoneDistance(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+	}{
+		{a: "berlin/mitte", b: "berlin/mitte", want: 0},
+		{a: "Berlin/Mitte", b: "berlin/mitte ", want: 0},
+		{a: "berlin/mitte", b: "berlin", want: 1},
+		{a: "berlin/mitte", b: "berlin/kreuzberg", want: 2},
+		{a: "berlin/mitte", b: "hamburg/altona", want: 4},
+		{a: "berlin/mitte", b: "", want: 2},
+		{a: "", b: "", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" to "+tt.b, func(t *testing.T) {
+			if got := ZoneDistance(tt.a, tt.b); got != tt.want {
+				t.Errorf("ZoneDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+			if got := ZoneDistance(tt.b, tt.a); got != tt.want {
+				t.Errorf("ZoneDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChoose(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		strategy   string
+		zone       string
+		candidates []Candidate
+		want       uint
+	}{
+		{
+			name:     "round robin picks the longest wait",
+			strategy: RoundRobin,
+			candidates: []Candidate{
+				{AgentID: 1, LastAssignedAt: now},
+				{AgentID: 2, LastAssignedAt: now.Add(-time.Hour)},
+				{AgentID: 3, LastAssignedAt: now.Add(-time.Minute)},
+			},
+			want: 2,
+		},
+		{
+			name:     "round robin picks a never assigned agent first",
+			strategy: RoundRobin,
+			candidates: []Candidate{
+				{AgentID: 1, LastAssignedAt: now.Add(-time.Hour)},
+				{AgentID: 2},
+			},
+			want: 2,
+		},
+		{
+			name:     "round robin breaks ties on the agent id",
+			strategy: RoundRobin,
+			candidates: []Candidate{
+				{AgentID: 3, LastAssignedAt: now},
+				{AgentID: 1, LastAssignedAt: now},
+			},
+			want: 1,
+		},
+		{
+			name:     "least loaded compares the share of capacity",
+			strategy: LeastLoaded,
+			candidates: []Candidate{
+				{AgentID: 1, Capacity: 2, Load: 1},
+				{AgentID: 2, Capacity: 4, Load: 1},
+				{AgentID: 3, Capacity: 1, Load: 0, LastAssignedAt: now},
+			},
+			want: 3,
+		},
+		{
+			name:     "least loaded prefers the bigger capacity at the same load",
+			strategy: LeastLoaded,
+			candidates: []Candidate{
+				{AgentID: 1, Capacity: 2, Load: 1},
+				{AgentID: 2, Capacity: 4, Load: 1},
+			},
+			want: 2,
+		},
+		{
+			name:     "least loaded falls back to round robin",
+			strategy: LeastLoaded,
+			candidates: []Candidate{
+				{AgentID: 1, Capacity: 2, Load: 1, LastAssignedAt: now},
+				{AgentID: 2, Capacity: 4, Load: 2, LastAssignedAt: now.Add(-time.Hour)},
+			},
+			want: 2,
+		},
+		{
+			name:     "nearest zone prefers the same zone",
+			strategy: NearestZone,
+			zone:     "berlin/mitte",
+			candidates: []Candidate{
+				{AgentID: 1, Zone: "berlin/kreuzberg", Capacity: 4},
+				{AgentID: 2, Zone: "berlin/mitte", Capacity: 4, Load: 3},
+			},
+			want: 2,
+		},
+		{
+			name:     "nearest zone falls back to the region",
+			strategy: NearestZone,
+			zone:     "berlin/mitte",
+			candidates: []Candidate{
+				{AgentID: 1, Zone: "hamburg/altona", Capacity: 4},
+				{AgentID: 2, Zone: "berlin/kreuzberg", Capacity: 4, Load: 3},
+			},
+			want: 2,
+		},
+		{
+			name:     "nearest zone picks the least loaded in the zone",
+			strategy: NearestZone,
+			zone:     "berlin/mitte",
+			candidates: []Candidate{
+				{AgentID: 1, Zone: "berlin/mitte", Capacity: 4, Load: 2},
+				{AgentID: 2, Zone: "berlin/mitte", Capacity: 4, Load: 1},
+				{AgentID: 3, Zone: "berlin/kreuzberg", Capacity: 4},
+			},
+			want: 2,
+		},
+		{
+			name:     "single candidate",
+			strategy: NearestZone,
+			zone:     "berlin/mitte",
+			candidates: []Candidate{
+				{AgentID: 5, Zone: "hamburg/altona", Capacity: 1, Load: 1},
+			},
+			want: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy, err := New(tt.strategy)
+			if err != nil {
+				t.Fatalf("New(%q) failed: %v", tt.strategy, err)
+			}
+			if got := strategy.Choose(tt.zone, tt.candidates); got.AgentID != tt.want {
+				t.Errorf("%s chose agent %d, want %d", tt.strategy, got.AgentID, tt.want)
+			}
+		})
+	}
+}
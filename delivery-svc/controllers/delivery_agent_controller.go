@@ -2,40 +2,78 @@ package controllers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/assignment"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/repository"
-	"github.com/opentracing/opentracing-go"
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/delivery-svc/controllers")
+
 type DeliveryAgentController struct {
 	DeliveryAgentRepository *repository.DeliveryAgentRepository
+	// Strategy chooses the agent of every reservation.
+	Strategy assignment.Strategy
 }
 
-func (c *DeliveryAgentController) ReserveDeliveryAgent(ctx context.Context) (uint, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx,
+func (c *DeliveryAgentController) ReserveDeliveryAgent(ctx context.Context, zone string,
+	holder string) (uint, error) {
+	ctx, span := tracer.Start(ctx,
 		"DeliveryAgentController.ReserveDeliveryAgent: reserve_delivery_agent")
-	defer span.Finish()
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
 
-	id, err := c.DeliveryAgentRepository.CreateReservation(
-		opentracing.ContextWithSpan(ctx, span),
-	)
+	id, err := c.DeliveryAgentRepository.CreateReservation(ctx, zone, holder, c.Strategy)
 	if err != nil {
-		log.Printf("[ERROR] Failed to create a reservation on that item\n")
+		slog.ErrorContext(ctx, "failed to create a delivery agent reservation",
+			"zone", zone, "strategy", c.Strategy.Name(), "error", err)
 	}
 	return id, err
 }
 
 func (c *DeliveryAgentController) BookDeliveryAgent(ctx context.Context,
 	reservationID int64, orderID string) error {
-	span, _ := opentracing.StartSpanFromContext(ctx,
+	ctx, span := tracer.Start(ctx,
 		"DeliveryAgentController.BookDeliveryAgent: book_delivery_agent")
-	defer span.Finish()
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.DeliveryAgentRepository.BookItem(ctx, reservationID, orderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to book the delivery agent",
+			"reservation_id", reservationID, "error", err)
+	}
+	return err
+}
+
+func (c *DeliveryAgentController) ReleaseDeliveryAgent(ctx context.Context,
+	reservationID int64, holder string) error {
+	ctx, span := tracer.Start(ctx,
+		"DeliveryAgentController.ReleaseDeliveryAgent: release_delivery_agent")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.DeliveryAgentRepository.ReleaseReservation(ctx, reservationID, holder)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release the delivery agent reservation",
+			"reservation_id", reservationID, "error", err)
+	}
+	return err
+}
+
+func (c *DeliveryAgentController) CancelBooking(ctx context.Context,
+	reservationID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx,
+		"DeliveryAgentController.CancelBooking: cancel_booking")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
 
-	err := c.DeliveryAgentRepository.BookItem(opentracing.ContextWithSpan(ctx, span),
-		reservationID, orderID)
+	err := c.DeliveryAgentRepository.CancelBooking(ctx, reservationID, orderID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to book the item")
+		slog.ErrorContext(ctx, "failed to cancel the delivery agent booking",
+			"reservation_id", reservationID, "error", err)
 	}
 	return err
 }
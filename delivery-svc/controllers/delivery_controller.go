@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/repository"
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+)
+
+type DeliveryController struct {
+	DeliveryRepository *repository.DeliveryRepository
+}
+
+func (c *DeliveryController) GetDelivery(ctx context.Context, orderID string) (*models.Delivery, error) {
+	ctx, span := tracer.Start(ctx, "DeliveryController.GetDelivery: get_delivery")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	delivery, err := c.DeliveryRepository.GetDelivery(ctx, orderID)
+	if err != nil && err != repository.ErrDeliveryNotFound {
+		slog.ErrorContext(ctx, "failed to get the delivery", "order_id", orderID, "error", err)
+	}
+	return delivery, err
+}
+
+// AdvanceDelivery moves the delivery of orderID to state and returns it as
+// it is afterwards.
+func (c *DeliveryController) AdvanceDelivery(ctx context.Context, orderID string,
+	state string, reason string) (*models.Delivery, error) {
+	ctx, span := tracer.Start(ctx, "DeliveryController.AdvanceDelivery: advance_delivery")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.DeliveryRepository.Transition(ctx, orderID, state, reason)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to advance the delivery",
+			"order_id", orderID, "state", state, "error", err)
+		return nil, err
+	}
+	slog.InfoContext(ctx, "delivery advanced", "order_id", orderID, "state", state)
+	return c.DeliveryRepository.GetDelivery(ctx, orderID)
+}
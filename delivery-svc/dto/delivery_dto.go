@@ -0,0 +1,23 @@
+package dto
+
+import "time"
+
+type DeliveryTransitionDto struct {
+	FromState string    `json:"fromState,omitempty"`
+	ToState   string    `json:"toState"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+type DeliveryDto struct {
+	OrderID         string                  `json:"orderId"`
+	DeliveryAgentID int                     `json:"deliveryAgentId"`
+	State           string                  `json:"state"`
+	History         []DeliveryTransitionDto `json:"history"`
+}
+
+type AdvanceDeliveryDto struct {
+	// State is the state to move to, such as "PICKED_UP".
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,9 @@
+package dto
+
+type ReserveDeliveryAgentDto struct {
+	// Zone is where the delivery goes, such as "berlin/mitte".
+	Zone string `json:"zone"`
+	// OrderID is the order the agent is reserved for, the Idempotency-Key
+	// of the request is used when it is empty.
+	OrderID string `json:"orderId,omitempty"`
+}
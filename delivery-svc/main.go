@@ -1,53 +1,91 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 
+	"github.com/Roy19/distributed-transaction-2pc/config"
 	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/assignment"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/controllers"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/dto"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/migrations"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/repository"
+	"github.com/Roy19/distributed-transaction-2pc/fixtures"
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/logging"
+	"github.com/Roy19/distributed-transaction-2pc/metrics"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+	"github.com/Roy19/distributed-transaction-2pc/sweeper"
 	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
 	"github.com/Roy19/distributed-transaction-2pc/utils"
 	"github.com/go-chi/chi/v5"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-var (
-	tracer opentracing.Tracer
-)
+// reservationHolder returns the order a reservation is taken for, orderID
+// or else the Idempotency-Key of the request. Only that order may book or
+// release the reservation afterwards.
+func reservationHolder(r *http.Request, orderID string) (string, error) {
+	if orderID != "" {
+		return orderID, nil
+	}
+	if key := r.Header.Get(idempotency.HeaderKey); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("an orderId or an %s header is required", idempotency.HeaderKey)
+}
 
-func initRoutes(mux *chi.Mux, controller *controllers.DeliveryAgentController) {
+func initRoutes(mux *chi.Mux, controller *controllers.DeliveryAgentController,
+	idempotencyKeys *idempotency.Store) {
 	mux.Get("/status", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
 	mux.Route("/agent", func(r chi.Router) {
 
-		r.Post("/reserve", func(w http.ResponseWriter, r *http.Request) {
-			spanCtx, _ := tracer.Extract(
-				opentracing.HTTPHeaders,
-				opentracing.HTTPHeadersCarrier(r.Header),
-			)
-
-			span := tracer.StartSpan("POST /agent/reserve: reserve_delivery_agent",
-				ext.RPCServerOption(spanCtx))
-			defer span.Finish()
-
-			ctx := opentracing.ContextWithSpan(r.Context(), span)
+		r.With(idempotencyKeys.Middleware).Post("/reserve", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
-			id, err := controller.ReserveDeliveryAgent(ctx)
+			// the body is optional, without a zone any agent may be chosen and
+			// the Idempotency-Key may name the order
+			var reserveDeliveryAgent dto.ReserveDeliveryAgentDto
+			defer r.Body.Close()
+			err := json.NewDecoder(r.Body).Decode(&reserveDeliveryAgent)
+			if err != nil && err != io.EOF {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			holder, err := reservationHolder(r, reserveDeliveryAgent.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			id, err := controller.ReserveDeliveryAgent(ctx, reserveDeliveryAgent.Zone, holder)
 			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, repository.ErrNoAgentAvailable) {
+					status = http.StatusConflict
+				}
 				errorMessage := map[string]any{
 					"error": err.Error(),
 				}
-				utils.Respond(w, http.StatusNotFound, errorMessage)
+				utils.Respond(w, status, errorMessage)
 				return
 			}
 			data := map[string]any{
@@ -57,17 +95,8 @@ func initRoutes(mux *chi.Mux, controller *controllers.DeliveryAgentController) {
 			utils.Respond(w, http.StatusOK, data)
 		})
 
-		r.Post("/book", func(w http.ResponseWriter, r *http.Request) {
-			spanCtx, _ := tracer.Extract(
-				opentracing.HTTPHeaders,
-				opentracing.HTTPHeadersCarrier(r.Header),
-			)
-
-			span := tracer.StartSpan("POST /agent/book: book_delivery_agent",
-				ext.RPCServerOption(spanCtx))
-			defer span.Finish()
-
-			ctx := opentracing.ContextWithSpan(r.Context(), span)
+		r.With(idempotencyKeys.Middleware).Post("/book", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
 
 			var bookDeliveryAgent dto.BookDeliveryAgentDto
 			data, err := ioutil.ReadAll(r.Body)
@@ -96,7 +125,7 @@ func initRoutes(mux *chi.Mux, controller *controllers.DeliveryAgentController) {
 				errorMessage := map[string]any{
 					"error": err.Error(),
 				}
-				utils.Respond(w, http.StatusInternalServerError, errorMessage)
+				utils.Respond(w, bookErrorStatus(err), errorMessage)
 				return
 			} else {
 				data := map[string]any{
@@ -105,35 +134,346 @@ func initRoutes(mux *chi.Mux, controller *controllers.DeliveryAgentController) {
 				utils.Respond(w, http.StatusOK, data)
 			}
 		})
+
+		r.Post("/release", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var releaseDeliveryAgent dto.ReleaseDeliveryAgentDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&releaseDeliveryAgent); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := controller.ReleaseDeliveryAgent(ctx, releaseDeliveryAgent.ReservationID,
+				releaseDeliveryAgent.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "delivery agent released",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.Post("/unbook", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var bookDeliveryAgent dto.BookDeliveryAgentDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&bookDeliveryAgent); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := controller.CancelBooking(ctx, bookDeliveryAgent.ReservationID,
+				bookDeliveryAgent.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "delivery agent booking cancelled",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+	})
+}
+
+func toDeliveryDto(delivery *models.Delivery) dto.DeliveryDto {
+	history := make([]dto.DeliveryTransitionDto, 0, len(delivery.Transitions))
+	for _, transition := range delivery.Transitions {
+		history = append(history, dto.DeliveryTransitionDto{
+			FromState: transition.FromState,
+			ToState:   transition.ToState,
+			Reason:    transition.Reason,
+			At:        transition.CreatedAt,
+		})
+	}
+	return dto.DeliveryDto{
+		OrderID:         delivery.OrderID,
+		DeliveryAgentID: delivery.DeliveryAgentID,
+		State:           delivery.State,
+		History:         history,
+	}
+}
+
+// bookErrorStatus maps errors booking a reservation to a status. A
+// reservation that expired is 410 Gone, it can never be booked and the
+// coordinator fails the order.
+func bookErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrReservationExpired) {
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// reservationErrorStatus maps errors releasing a reservation or cancelling
+// a booking to a status. Only a reservation or booking that does not exist
+// is a 404, which the coordinator takes as already undone.
+func reservationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrReservationNotFound),
+		errors.Is(err, repository.ErrBookingNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrDeliveryUnderway):
+		// the booking is not gone, the coordinator must not take it as undone
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// deliveryErrorStatus maps errors of the delivery repository to a status.
+func deliveryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrDeliveryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrInvalidTransition):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func initDeliveryRoutes(mux *chi.Mux, controller *controllers.DeliveryController) {
+	mux.Route("/delivery/{orderID}", func(r chi.Router) {
+
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			delivery, err := controller.GetDelivery(r.Context(), chi.URLParam(r, "orderID"))
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, deliveryErrorStatus(err), errorMessage)
+				return
+			}
+			utils.Respond(w, http.StatusOK, toDeliveryDto(delivery))
+		})
+
+		r.Post("/state", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var advanceDelivery dto.AdvanceDeliveryDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&advanceDelivery); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			if !models.IsDeliveryState(advanceDelivery.State) {
+				errorMessage := map[string]any{
+					"error": fmt.Sprintf("unknown delivery state %q", advanceDelivery.State),
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			delivery, err := controller.AdvanceDelivery(ctx, chi.URLParam(r, "orderID"),
+				advanceDelivery.State, advanceDelivery.Reason)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, deliveryErrorStatus(err), errorMessage)
+				return
+			}
+			utils.Respond(w, http.StatusOK, toDeliveryDto(delivery))
+		})
 	})
 }
 
-func initDistributedTracer() opentracing.Tracer {
-	tracer, err := distributedTracer.GetTracer("delivery-svc", os.Getenv("JAEGER_AGENT_HOST"))
+// initDistributedTracer installs the tracer and returns its shutdown.
+func initDistributedTracer() func(ctx context.Context) error {
+	_, shutdown, err := distributedTracer.GetTracer(distributedTracer.ConfigFromEnv("delivery-svc"))
 	if err != nil {
 		log.Fatal("failed to initialize tracer")
 	}
-	opentracing.SetGlobalTracer(tracer)
-	return tracer
+	return shutdown
+}
+
+// shutdownTracer exports the spans still buffered before the process exits.
+func shutdownTracer(shutdown func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), utils.ShutdownTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("failed to flush spans", "error", err)
+	}
+}
+
+func initMetrics(repository *repository.DeliveryAgentRepository) http.Handler {
+	handler, err := metrics.Init("delivery-svc")
+	if err != nil {
+		log.Fatal("failed to initialize metrics")
+	}
+	err = metrics.ObserveGauge("github.com/Roy19/distributed-transaction-2pc/delivery-svc",
+		"delivery.reservations.free", "Number of delivery agents free to be reserved.",
+		func(ctx context.Context, report func(value int64, attributes ...attribute.KeyValue)) error {
+			free, err := repository.CountFreeReservations(ctx)
+			if err != nil {
+				return err
+			}
+			report(free)
+			return nil
+		})
+	if err != nil {
+		log.Fatal("failed to register the free reservations gauge")
+	}
+	return handler
+}
+
+// initSchema runs the migrate command when it is given after the flags
+// and exits. Otherwise it applies pending migrations, unless
+// cfg.MigrateOnStart is false.
+func initSchema(cfg *config.Config) {
+	args := cfg.Args
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		log.Fatal(err)
+	}
+	migrator := &migrate.Migrator{
+		DB:         client,
+		Service:    "delivery-svc",
+		Migrations: migrations.Migrations,
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		err := migrator.Run(context.Background(), args[1:], os.Stdout)
+		if closeErr := db.Close(); closeErr != nil {
+			slog.Error("failed to close the database", "error", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if !cfg.MigrateOnStart {
+		return
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// initFixtures runs the seed command when it is given after the flags and
+// exits, the command seeds the file it is given or the configured one.
+// Otherwise it applies the configured fixtures when seeding is enabled.
+func initFixtures(cfg *config.Config, repository *repository.DeliveryAgentRepository) {
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "seed" {
+			log.Fatalf("unknown command %s, use migrate or seed", cfg.Args[0])
+		}
+		path := cfg.Fixtures
+		if len(cfg.Args) > 1 {
+			path = cfg.Args[1]
+		}
+		err := seed(context.Background(), repository, path)
+		if closeErr := db.Close(); closeErr != nil {
+			slog.Error("failed to close the database", "error", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if !cfg.Seed {
+		return
+	}
+	if err := seed(context.Background(), repository, cfg.Fixtures); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// seed applies the delivery agents of the fixture file at path.
+func seed(ctx context.Context, repository *repository.DeliveryAgentRepository, path string) error {
+	loaded, err := fixtures.Load(path)
+	if err != nil {
+		return err
+	}
+	for _, agent := range loaded.Agents {
+		added, err := repository.SeedAgent(ctx, models.DeliveryAgent{
+			Name:       agent.Name,
+			Zone:       agent.Zone,
+			Capacity:   *agent.Capacity,
+			ShiftStart: agent.ShiftStart,
+			ShiftEnd:   agent.ShiftEnd,
+		})
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "seeded delivery agent", "agent", agent.Name,
+			"capacity", *agent.Capacity, "added", added)
+	}
+	return nil
 }
 
-func initDependencies() *controllers.DeliveryAgentController {
-	store_dsn := os.Getenv("DELIVERY_DSN")
-	db.InitDB(store_dsn, "delivery-svc")
-	tracer = initDistributedTracer()
-	opentracing.SetGlobalTracer(tracer)
-	db.MigrateModels("delivery-svc", models.DeliveryAgentReservation{})
-	db.PutDummyDataDeliveryAgent("delivery-svc")
+func initDependencies(cfg *config.Config) *controllers.DeliveryAgentController {
+	if err := db.InitDB(cfg.DSN, "delivery-svc", db.PoolConfigFromEnv(), cfg.DBTraceStatements); err != nil {
+		log.Fatal(err)
+	}
+	initSchema(cfg)
+	repository := &repository.DeliveryAgentRepository{
+		ReservationTTL: cfg.ReservationTTL,
+	}
+	initFixtures(cfg, repository)
+	strategy, err := assignment.New(cfg.AssignmentStrategy)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return &controllers.DeliveryAgentController{
-		DeliveryAgentRepository: &repository.DeliveryAgentRepository{},
+		DeliveryAgentRepository: repository,
+		Strategy:                strategy,
 	}
 }
 
 func main() {
+	logging.Init(logging.ConfigFromEnv("delivery-svc"))
+	cfg, err := config.Load(config.Service{
+		Name:        "delivery-svc",
+		EnvPrefix:   "DELIVERY",
+		DefaultPort: 8081,
+	}, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdown := initDistributedTracer()
 	mux := chi.NewRouter()
-	controller := initDependencies()
-	initRoutes(mux, controller)
-	if err := http.ListenAndServe(":8081", mux); err != nil {
+	mux.Use(distributedTracer.Middleware)
+	mux.Use(metrics.Middleware)
+	controller := initDependencies(cfg)
+	mux.Handle("/metrics", initMetrics(controller.DeliveryAgentRepository))
+	idempotencyKeys := &idempotency.Store{
+		ServiceName: "delivery-svc",
+		Retention:   cfg.IdempotencyRetention,
+		Lease:       cfg.IdempotencyLease,
+	}
+	initRoutes(mux, controller, idempotencyKeys)
+	initDeliveryRoutes(mux, &controllers.DeliveryController{
+		DeliveryRepository: &repository.DeliveryRepository{},
+	})
+	reservationSweeper := &sweeper.Sweeper{
+		ServiceName: "delivery-svc",
+		Interval:    cfg.SweepInterval,
+		Sweep:       controller.DeliveryAgentRepository.ReleaseExpired,
+	}
+	go reservationSweeper.Run(context.Background())
+	err = utils.ListenAndServe(cfg.Addr(), mux)
+	shutdownTracer(shutdown)
+	if closeErr := db.Close(); closeErr != nil {
+		slog.Error("failed to close the database", "error", closeErr)
+	}
+	if err != nil {
 		log.Fatal("failed to start server")
 	}
 }
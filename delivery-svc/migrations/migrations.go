@@ -0,0 +1,120 @@
+package migrations
+
+import (
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+)
+
+// Migrations are the schema changes of delivery-svc, in the order they were
+// made. Tables are created if missing so that databases created by
+// AutoMigrate can be brought under migrations.
+var Migrations = []migrate.Migration{
+	migrate.SQL(1, "create_delivery_agent_reservations", `
+		create table if not exists delivery_agent_reservations (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			is_reserved boolean,
+			current_order_id text
+		);
+		create index if not exists idx_delivery_agent_reservations_deleted_at
+			on delivery_agent_reservations (deleted_at)`, `
+		drop table if exists delivery_agent_reservations`),
+	migrate.SQL(2, "add_reservation_expiry", `
+		alter table delivery_agent_reservations
+			add column if not exists reserved_at timestamptz,
+			add column if not exists expires_at timestamptz;
+		create index if not exists idx_delivery_agent_reservations_expires_at
+			on delivery_agent_reservations (expires_at)`, `
+		drop index if exists idx_delivery_agent_reservations_expires_at;
+		alter table delivery_agent_reservations
+			drop column if exists expires_at,
+			drop column if exists reserved_at`),
+	idempotency.Migration(3, "delivery-svc"),
+	// every reservation made before agents existed becomes an agent of its
+	// own, with the same ID, who works in every zone at any time
+	migrate.SQL(4, "create_delivery_agents", `
+		create table if not exists delivery_agents (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			name text not null,
+			zone text not null default '',
+			capacity integer not null default 1,
+			shift_start text not null default '00:00',
+			shift_end text not null default '24:00',
+			last_assigned_at timestamptz
+		);
+		create index if not exists idx_delivery_agents_deleted_at on delivery_agents (deleted_at);
+		create unique index if not exists idx_delivery_agents_name
+			on delivery_agents (name) where deleted_at is null;
+		alter table delivery_agent_reservations
+			add column if not exists delivery_agent_id bigint
+				constraint fk_delivery_agent_reservations_delivery_agent
+				references delivery_agents (id);
+		insert into delivery_agents (id, created_at, updated_at, name)
+			select id, now(), now(), 'agent-' || id from delivery_agent_reservations
+			where delivery_agent_id is null;
+		update delivery_agent_reservations set delivery_agent_id = id
+			where delivery_agent_id is null;
+		select setval(pg_get_serial_sequence('delivery_agents', 'id'),
+			coalesce(max(id), 0) + 1, false) from delivery_agents;
+		create index if not exists idx_delivery_agent_reservations_delivery_agent_id
+			on delivery_agent_reservations (delivery_agent_id)`, `
+		drop index if exists idx_delivery_agent_reservations_delivery_agent_id;
+		alter table delivery_agent_reservations drop column if exists delivery_agent_id;
+		drop table if exists delivery_agents`),
+	// orders booked before deliveries were tracked start out ASSIGNED
+	migrate.SQL(5, "create_deliveries", `
+		create table if not exists deliveries (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			order_id text not null,
+			delivery_agent_id bigint not null
+				constraint fk_deliveries_delivery_agent references delivery_agents (id),
+			delivery_agent_reservation_id bigint not null
+				constraint fk_deliveries_delivery_agent_reservation
+				references delivery_agent_reservations (id),
+			state text not null
+		);
+		create index if not exists idx_deliveries_deleted_at on deliveries (deleted_at);
+		create unique index if not exists idx_deliveries_order_id
+			on deliveries (order_id) where deleted_at is null;
+		create index if not exists idx_deliveries_delivery_agent_id
+			on deliveries (delivery_agent_id);
+		create index if not exists idx_deliveries_state on deliveries (state);
+		create table if not exists delivery_transitions (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			delivery_id bigint not null
+				constraint fk_deliveries_transitions references deliveries (id),
+			from_state text not null,
+			to_state text not null,
+			reason text
+		);
+		create index if not exists idx_delivery_transitions_deleted_at
+			on delivery_transitions (deleted_at);
+		create index if not exists idx_delivery_transitions_delivery_id
+			on delivery_transitions (delivery_id);
+		insert into deliveries (created_at, updated_at, order_id, delivery_agent_id,
+				delivery_agent_reservation_id, state)
+			select now(), now(), current_order_id, delivery_agent_id, id, 'ASSIGNED'
+			from delivery_agent_reservations
+			where current_order_id is not null and deleted_at is null;
+		insert into delivery_transitions (created_at, updated_at, delivery_id,
+				from_state, to_state, reason)
+			select now(), now(), id, '', 'ASSIGNED', 'booked before deliveries were tracked'
+			from deliveries`, `
+		drop table if exists delivery_transitions;
+		drop table if exists deliveries`),
+	migrate.SQL(6, "add_reservation_holder", `
+		alter table delivery_agent_reservations add column if not exists holder text`, `
+		alter table delivery_agent_reservations drop column if exists holder`),
+	idempotency.LeaseMigration(7),
+}
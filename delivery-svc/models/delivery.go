@@ -0,0 +1,62 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	DeliveryAssigned  = "ASSIGNED"
+	DeliveryPickedUp  = "PICKED_UP"
+	DeliveryInTransit = "IN_TRANSIT"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+// deliveryTransitions lists the states each unfinished state can move to.
+var deliveryTransitions = map[string][]string{
+	DeliveryAssigned:  {DeliveryPickedUp, DeliveryFailed},
+	DeliveryPickedUp:  {DeliveryInTransit, DeliveryFailed},
+	DeliveryInTransit: {DeliveryDelivered, DeliveryFailed},
+}
+
+// IsDeliveryState reports whether state is a state of a Delivery.
+func IsDeliveryState(state string) bool {
+	_, ok := deliveryTransitions[state]
+	return ok || IsTerminalDeliveryState(state)
+}
+
+// IsTerminalDeliveryState reports whether a delivery in state is over.
+func IsTerminalDeliveryState(state string) bool {
+	return state == DeliveryDelivered || state == DeliveryFailed
+}
+
+// CanTransition reports whether a delivery may move from one state to
+// another.
+func CanTransition(from string, to string) bool {
+	for _, next := range deliveryTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is the delivery of a booked order by an agent. It starts
+// ASSIGNED and ends DELIVERED or FAILED, which gives the reservation it
+// holds back to the agent.
+type Delivery struct {
+	gorm.Model
+	OrderID                    string `gorm:"not null"`
+	DeliveryAgentID            int    `gorm:"index;not null"`
+	DeliveryAgentReservationID uint   `gorm:"not null"`
+	State                      string `gorm:"index;not null"`
+	Transitions                []DeliveryTransition
+}
+
+// DeliveryTransition records a change of state of a Delivery. FromState is
+// empty for the transition that created it.
+type DeliveryTransition struct {
+	gorm.Model
+	DeliveryID uint   `gorm:"index;not null"`
+	FromState  string `gorm:"not null"`
+	ToState    string `gorm:"not null"`
+	Reason     string
+}
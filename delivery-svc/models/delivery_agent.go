@@ -0,0 +1,23 @@
+package models
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+// DeliveryAgent is a courier. Its reservations are the deliveries it can
+// hold at once, one per unit of Capacity.
+type DeliveryAgent struct {
+	gorm.Model
+	Name string `gorm:"not null"`
+	// Zone is a slash separated path from the region down to the zone the
+	// agent works in, such as "berlin/mitte".
+	Zone     string `gorm:"not null;default:''"`
+	Capacity int    `gorm:"not null;default:1"`
+	// ShiftStart and ShiftEnd are the HH:MM times of day in UTC the agent
+	// works between, a shift that ends before it starts runs past midnight.
+	ShiftStart     string `gorm:"not null;default:'00:00'"`
+	ShiftEnd       string `gorm:"not null;default:'24:00'"`
+	LastAssignedAt sql.NullTime
+}
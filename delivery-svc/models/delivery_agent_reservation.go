@@ -8,6 +8,13 @@ import (
 
 type DeliveryAgentReservation struct {
 	gorm.Model
-	IsReserved     bool
-	CurrentOrderID sql.NullString
+	DeliveryAgentID int
+	DeliveryAgent   DeliveryAgent
+	IsReserved      bool
+	CurrentOrderID  sql.NullString
+	ReservedAt      sql.NullTime
+	ExpiresAt       sql.NullTime `gorm:"index"`
+	// Holder is the order that reserved the reservation, only it may book
+	// or release it.
+	Holder sql.NullString
 }
@@ -0,0 +1,63 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: DeliveryAssigned, to: DeliveryPickedUp, want: true},
+		{from: DeliveryAssigned, to: DeliveryFailed, want: true},
+		{from: DeliveryAssigned, to: DeliveryInTransit},
+		{from: DeliveryAssigned, to: DeliveryDelivered},
+		{from: DeliveryAssigned, to: DeliveryAssigned},
+		{from: DeliveryPickedUp, to: DeliveryInTransit, want: true},
+		{from: DeliveryPickedUp, to: DeliveryFailed, want: true},
+		{from: DeliveryPickedUp, to: DeliveryAssigned},
+		{from: DeliveryPickedUp, to: DeliveryDelivered},
+		{from: DeliveryInTransit, to: DeliveryDelivered, want: true},
+		{from: DeliveryInTransit, to: DeliveryFailed, want: true},
+		{from: DeliveryInTransit, to: DeliveryPickedUp},
+		{from: DeliveryDelivered, to: DeliveryFailed},
+		{from: DeliveryDelivered, to: DeliveryAssigned},
+		{from: DeliveryFailed, to: DeliveryAssigned},
+		{from: DeliveryFailed, to: DeliveryDelivered},
+		{from: "LOST", to: DeliveryFailed},
+		{from: DeliveryAssigned, to: "LOST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeliveryStates(t *testing.T) {
+	tests := []struct {
+		state        string
+		wantState    bool
+		wantTerminal bool
+	}{
+		{state: DeliveryAssigned, wantState: true},
+		{state: DeliveryPickedUp, wantState: true},
+		{state: DeliveryInTransit, wantState: true},
+		{state: DeliveryDelivered, wantState: true, wantTerminal: true},
+		{state: DeliveryFailed, wantState: true, wantTerminal: true},
+		{state: "assigned"},
+		{state: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			if got := IsDeliveryState(tt.state); got != tt.wantState {
+				t.Errorf("IsDeliveryState(%q) = %v, want %v", tt.state, got, tt.wantState)
+			}
+			if got := IsTerminalDeliveryState(tt.state); got != tt.wantTerminal {
+				t.Errorf("IsTerminalDeliveryState(%q) = %v, want %v", tt.state, got, tt.wantTerminal)
+			}
+		})
+	}
+}
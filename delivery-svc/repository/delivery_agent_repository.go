@@ -2,63 +2,408 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/Roy19/distributed-transaction-2pc/config"
 	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/assignment"
 	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
-	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
 )
 
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/delivery-svc/repository")
+
+// DefaultReservationTTL is used when a DeliveryAgentRepository has no ReservationTTL.
+const DefaultReservationTTL = config.DefaultReservationTTL
+
 type DeliveryAgentRepository struct {
+	// ReservationTTL is how long a reservation is held before the sweeper
+	// may release it.
+	ReservationTTL time.Duration
 }
 
-func (s *DeliveryAgentRepository) CreateReservation(ctx context.Context) (uint, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "CreateReservation: create_reservation on db")
-	defer span.Finish()
+func (s *DeliveryAgentRepository) reservationTTL() time.Duration {
+	if s.ReservationTTL <= 0 {
+		return DefaultReservationTTL
+	}
+	return s.ReservationTTL
+}
 
-	txn := db.GetDBClient("delivery-svc").Model(&models.DeliveryAgentReservation{}).Begin()
-	var deliveryAgentReservation models.DeliveryAgentReservation
-	txn = txn.Raw(`select * from delivery_agent_reservations 
-		where is_reserved = false and current_order_id is null
-		limit 1
-		for update`).Scan(&deliveryAgentReservation)
-	if txn.Error != nil || txn.RowsAffected == 0 {
-		txn.Rollback()
-		return 0, fmt.Errorf("no more reservations can be done on item")
+var (
+	// ErrNoAgentAvailable is returned when no agent on shift has a free
+	// reservation.
+	ErrNoAgentAvailable = errors.New("no delivery agent is available")
+	// ErrReservationNotFound is returned when there is no reservation held
+	// by the holder to release, most likely because it was released
+	// already.
+	ErrReservationNotFound = errors.New("no reservation found to release")
+	// ErrBookingNotFound is returned when there is no booking of the order
+	// to cancel, most likely because it was cancelled already.
+	ErrBookingNotFound = errors.New("no booking found to cancel")
+	// ErrReservationExpired is returned when a reservation to book is no
+	// longer held by the order, the sweeper released it once it expired.
+	ErrReservationExpired = errors.New("reservation is no longer held")
+)
+
+// candidates returns the agents on shift at clock, an HH:MM time of day in
+// UTC, who have a free reservation.
+func candidates(tx *gorm.DB, clock string) ([]assignment.Candidate, error) {
+	var rows []struct {
+		AgentID        uint
+		Zone           string
+		Capacity       int
+		Active         int
+		LastAssignedAt sql.NullTime
 	}
-	txn = txn.Exec(`update delivery_agent_reservations
-			set is_reserved = true
-			where id = ?`, deliveryAgentReservation.ID)
-	if txn.Error != nil {
-		txn.Rollback()
-		span.SetTag("error", true)
+	err := tx.Raw(`select delivery_agents.id as agent_id, delivery_agents.zone,
+			delivery_agents.capacity, delivery_agents.last_assigned_at,
+			count(*) filter (where delivery_agent_reservations.is_reserved or
+				delivery_agent_reservations.current_order_id is not null) as active
+		from delivery_agents
+		join delivery_agent_reservations on
+			delivery_agent_reservations.delivery_agent_id = delivery_agents.id and
+			delivery_agent_reservations.deleted_at is null
+		where delivery_agents.deleted_at is null and (
+			(shift_start <= shift_end and shift_start <= @clock and @clock < shift_end) or
+			(shift_start > shift_end and (shift_start <= @clock or @clock < shift_end)))
+		group by delivery_agents.id
+		having count(*) filter (where not delivery_agent_reservations.is_reserved and
+			delivery_agent_reservations.current_order_id is null) > 0`,
+		sql.Named("clock", clock)).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]assignment.Candidate, 0, len(rows))
+	for _, row := range rows {
+		candidates = append(candidates, assignment.Candidate{
+			AgentID:        row.AgentID,
+			Zone:           row.Zone,
+			Capacity:       row.Capacity,
+			Load:           row.Active,
+			LastAssignedAt: row.LastAssignedAt.Time,
+		})
+	}
+	return candidates, nil
+}
+
+// CreateReservation reserves a delivery in zone for holder with the agent
+// chosen by strategy among those on shift, and returns the reservation ID.
+func (s *DeliveryAgentRepository) CreateReservation(ctx context.Context, zone string,
+	holder string, strategy assignment.Strategy) (uint, error) {
+	ctx, span := tracer.Start(ctx, "CreateReservation: create_reservation on db")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("delivery.zone", zone),
+		attribute.String("assignment.strategy", strategy.Name()),
+	)
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	var reservationID uint
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// shifts are kept in UTC
+		reservedAt := time.Now()
+		available, err := candidates(tx, reservedAt.UTC().Format("15:04"))
+		if err != nil {
+			return err
+		}
+		span.SetAttributes(attribute.Int("assignment.candidates", len(available)))
+		// another reservation may take the last free reservation of the
+		// chosen agent meanwhile, the next best agent is tried then
+		for len(available) > 0 {
+			chosen := strategy.Choose(zone, available)
+			var ids []uint
+			err := tx.Raw(`select id from delivery_agent_reservations
+				where delivery_agent_id = ? and is_reserved = false and
+				current_order_id is null and deleted_at is null
+				order by id limit 1
+				for update skip locked`, chosen.AgentID).Scan(&ids).Error
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				for i, candidate := range available {
+					if candidate.AgentID == chosen.AgentID {
+						available = append(available[:i], available[i+1:]...)
+						break
+					}
+				}
+				continue
+			}
+			err = tx.Exec(`update delivery_agent_reservations
+				set is_reserved = true, reserved_at = ?, expires_at = ?, holder = ?
+				where id = ?`, reservedAt, reservedAt.Add(s.reservationTTL()), holder, ids[0]).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Exec(`update delivery_agents set last_assigned_at = ? where id = ?`,
+				reservedAt, chosen.AgentID).Error
+			if err != nil {
+				return err
+			}
+			span.SetAttributes(
+				attribute.Int64("delivery_agent.id", int64(chosen.AgentID)),
+				attribute.String("delivery_agent.zone", chosen.Zone),
+			)
+			reservationID = ids[0]
+			return nil
+		}
+		return ErrNoAgentAvailable
+	})
+	if err == ErrNoAgentAvailable {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return 0, fmt.Errorf("failed to set lock on delivery agent reservation")
 	}
-	txn.Commit()
-	return deliveryAgentReservation.ID, nil
+	return reservationID, nil
 }
 
+// BookItem books the reserved agent for orderID, which must be the order
+// that reserved it, and starts the delivery of the order.
 func (c *DeliveryAgentRepository) BookItem(ctx context.Context, reservationID int64, orderID string) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "BookItem: book an item on db")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "BookItem: book an item on db")
+	defer span.End()
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	var found bool
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var deliveryAgentReservation models.DeliveryAgentReservation
+		txOut := tx.Raw(`select * from delivery_agent_reservations 
+			where is_reserved = true and id = ? and holder = ?
+			for update`, uint(reservationID), orderID).Scan(&deliveryAgentReservation)
+		if txOut.Error != nil || txOut.RowsAffected == 0 {
+			return txOut.Error
+		}
+		found = true
+		err := tx.Exec(`update delivery_agent_reservations
+				set is_reserved = false, current_order_id = ?, expires_at = null
+				where id = ?`, orderID, uint(reservationID)).Error
+		if err != nil {
+			return err
+		}
+		return createDelivery(tx, orderID, deliveryAgentReservation)
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to set lock on delivery agent reservation")
+	}
+	if !found {
+		return ErrReservationExpired
+	}
+	return nil
+}
+
+// ReleaseReservation frees a reservation that holder reserved and has not
+// booked.
+func (c *DeliveryAgentRepository) ReleaseReservation(ctx context.Context, reservationID int64,
+	holder string) error {
+	ctx, span := tracer.Start(ctx, "ReleaseReservation: release_reservation on db")
+	defer span.End()
 
-	txn := db.GetDBClient("delivery-svc").Model(&models.DeliveryAgentReservation{}).Begin()
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Model(&models.DeliveryAgentReservation{}).Begin()
 	var deliveryAgentReservation models.DeliveryAgentReservation
 	txn = txn.Raw(`select * from delivery_agent_reservations 
-		where is_reserved = true and id = ?
-		for update`, uint(reservationID)).Scan(&deliveryAgentReservation)
-	if txn.Error != nil || txn.RowsAffected == 0 {
+		where is_reserved = true and current_order_id is null and id = ? and holder = ?
+		for update`, uint(reservationID), holder).Scan(&deliveryAgentReservation)
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to find delivery agent reservation")
+	}
+	if txn.RowsAffected == 0 {
 		txn.Rollback()
-		return fmt.Errorf("no more reservations can be done on item")
+		return ErrReservationNotFound
 	}
 	txn = txn.Exec(`update delivery_agent_reservations
-			set is_reserved = false, current_order_id = ?
-			where id = ?`, orderID, uint(reservationID))
+			set is_reserved = false, reserved_at = null, expires_at = null, holder = null
+			where id = ?`, uint(reservationID))
 	if txn.Error != nil {
 		txn.Rollback()
-		span.SetTag("error", true)
-		return fmt.Errorf("failed to set lock on delivery agent reservation")
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to release lock on delivery agent reservation")
 	}
 	txn.Commit()
 	return nil
 }
+
+// CancelBooking frees the agent booked for orderID and fails its delivery,
+// unless the delivery was already picked up.
+func (c *DeliveryAgentRepository) CancelBooking(ctx context.Context, reservationID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "CancelBooking: cancel_booking on db")
+	defer span.End()
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	var found bool
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var deliveryAgentReservation models.DeliveryAgentReservation
+		txOut := tx.Raw(`select * from delivery_agent_reservations 
+			where current_order_id = ? and id = ?
+			for update`, orderID, uint(reservationID)).Scan(&deliveryAgentReservation)
+		if txOut.Error != nil || txOut.RowsAffected == 0 {
+			return txOut.Error
+		}
+		found = true
+		delivery, err := lockDelivery(tx, orderID)
+		if err != nil && err != ErrDeliveryNotFound {
+			return err
+		}
+		if delivery != nil {
+			if delivery.State != models.DeliveryAssigned {
+				return ErrDeliveryUnderway
+			}
+			// failing the delivery frees the reservation as well
+			return transition(tx, delivery, models.DeliveryFailed, "booking cancelled")
+		}
+		return tx.Exec(`update delivery_agent_reservations
+				set is_reserved = false, current_order_id = null,
+					reserved_at = null, expires_at = null, holder = null
+				where id = ?`, uint(reservationID)).Error
+	})
+	if err == ErrDeliveryUnderway {
+		return err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to cancel booking on delivery agent reservation")
+	}
+	if !found {
+		return ErrBookingNotFound
+	}
+	return nil
+}
+
+// ReleaseExpired frees every reservation whose hold has expired without the
+// delivery agent being booked and returns how many were freed.
+func (c *DeliveryAgentRepository) ReleaseExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ReleaseExpired: release_expired_reservations on db")
+	defer span.End()
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	txn := client.WithContext(ctx).Exec(`update delivery_agent_reservations
+			set is_reserved = false, reserved_at = null, expires_at = null, holder = null
+			where is_reserved = true and current_order_id is null and
+			expires_at < ?`, time.Now())
+	if txn.Error != nil {
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return 0, fmt.Errorf("failed to release expired delivery agent reservations")
+	}
+	return txn.RowsAffected, nil
+}
+
+// CountFreeReservations returns how many delivery agents are free to be
+// reserved.
+func (c *DeliveryAgentRepository) CountFreeReservations(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "CountFreeReservations: count_free_reservations on db")
+	defer span.End()
+
+	var free int64
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	err = client.WithContext(ctx).Model(&models.DeliveryAgentReservation{}).
+		Where("is_reserved = false and current_order_id is null").
+		Count(&free).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, fmt.Errorf("failed to count free delivery agent reservations")
+	}
+	return free, nil
+}
+
+// SeedAgent creates the agent called agent.Name or updates its zone,
+// capacity and shift, then gives it one reservation per unit of capacity.
+// It returns how many reservations it added. Seeding the same agent again
+// changes nothing.
+func (c *DeliveryAgentRepository) SeedAgent(ctx context.Context, agent models.DeliveryAgent) (int64, error) {
+	ctx, span := tracer.Start(ctx, "SeedAgent: seed_agent on db")
+	defer span.End()
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	var added int64
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// instances seeding at the same time must not create the agent twice
+		err := tx.Exec("select pg_advisory_xact_lock(hashtext(?))", "delivery_agents:"+agent.Name).Error
+		if err != nil {
+			return err
+		}
+		var existing models.DeliveryAgent
+		err = tx.Where("name = ?", agent.Name).
+			Assign(map[string]any{
+				"zone":        agent.Zone,
+				"capacity":    agent.Capacity,
+				"shift_start": agent.ShiftStart,
+				"shift_end":   agent.ShiftEnd,
+			}).
+			FirstOrCreate(&existing, models.DeliveryAgent{Name: agent.Name}).Error
+		if err != nil {
+			return err
+		}
+		var slots int64
+		err = tx.Model(&models.DeliveryAgentReservation{}).
+			Where("delivery_agent_id = ?", existing.ID).Count(&slots).Error
+		if err != nil {
+			return err
+		}
+		missing := int64(agent.Capacity) - slots
+		if missing < 0 {
+			// a lowered capacity only gives up reservations that are free
+			return tx.Exec(`update delivery_agent_reservations set deleted_at = now()
+				where id in (select id from delivery_agent_reservations
+					where delivery_agent_id = ? and is_reserved = false and
+					current_order_id is null and deleted_at is null
+					order by id desc limit ?)`, existing.ID, -missing).Error
+		}
+		if missing == 0 {
+			return nil
+		}
+		reservations := make([]models.DeliveryAgentReservation, missing)
+		for i := range reservations {
+			reservations[i].DeliveryAgentID = int(existing.ID)
+		}
+		if err := tx.Create(&reservations).Error; err != nil {
+			return err
+		}
+		added = missing
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, fmt.Errorf("failed to seed delivery agent %s: %w", agent.Name, err)
+	}
+	return added, nil
+}
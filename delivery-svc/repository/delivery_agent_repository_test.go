@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/db/dbtest"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
+)
+
+// fakeFleet registers a fake delivery-svc database holding agents by name
+// and the number of reservations of each agent.
+func fakeFleet(t *testing.T) (*dbtest.DB, map[string]int64, map[int64]int64) {
+	fake := dbtest.New(t)
+	if err := db.Register("delivery-svc", fake.Gorm()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	agents := make(map[string]int64)
+	slots := make(map[int64]int64)
+	fake.On(`^select pg_advisory_xact_lock`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+	fake.On(`^SELECT \* FROM "delivery_agents" WHERE name = \$1`, func(query dbtest.Query) dbtest.Result {
+		result := dbtest.Result{Columns: []string{"id", "name"}}
+		if id, ok := agents[query.Args[0].(string)]; ok {
+			result.Rows = [][]driver.Value{{id, query.Args[0]}}
+		}
+		return result
+	})
+	fake.On(`^INSERT INTO "delivery_agents"`, func(query dbtest.Query) dbtest.Result {
+		id := int64(len(agents) + 1)
+		agents[query.Rows()[0]["name"].(string)] = id
+		return dbtest.Rows("id", id)
+	})
+	fake.On(`^UPDATE "delivery_agents"`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{RowsAffected: 1}
+	})
+	fake.On(`^SELECT count\(\*\) FROM "delivery_agent_reservations" WHERE delivery_agent_id = \$1`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Rows("count", slots[query.Args[0].(int64)])
+	})
+	fake.On(`^INSERT INTO "delivery_agent_reservations"`, func(query dbtest.Query) dbtest.Result {
+		result := dbtest.Result{Columns: []string{"id"}}
+		for _, row := range query.Rows() {
+			slots[row["delivery_agent_id"].(int64)]++
+			result.Rows = append(result.Rows, []driver.Value{int64(len(result.Rows) + 1)})
+		}
+		return result
+	})
+	fake.On(`^update delivery_agent_reservations set deleted_at = now\(\)`, func(query dbtest.Query) dbtest.Result {
+		// every reservation is free in the fake
+		removed := query.Args[1].(int64)
+		slots[query.Args[0].(int64)] -= removed
+		return dbtest.Result{RowsAffected: removed}
+	})
+	return fake, agents, slots
+}
+
+func TestSeedAgent(t *testing.T) {
+	fake, agents, slots := fakeFleet(t)
+	repository := &DeliveryAgentRepository{}
+	ctx := context.Background()
+	agent := models.DeliveryAgent{Name: "ada", Zone: "berlin/mitte", Capacity: 3,
+		ShiftStart: "08:00", ShiftEnd: "16:00"}
+
+	added, err := repository.SeedAgent(ctx, agent)
+	if err != nil {
+		t.Fatalf("SeedAgent failed: %v", err)
+	}
+	if added != 3 || slots[agents["ada"]] != 3 {
+		t.Errorf("first seed added %d reservations, the agent has %d, want 3", added, slots[agents["ada"]])
+	}
+
+	// seeding again finds the agent and its reservations and adds nothing
+	fake.Reset()
+	added, err = repository.SeedAgent(ctx, agent)
+	if err != nil {
+		t.Fatalf("SeedAgent again failed: %v", err)
+	}
+	if added != 0 || len(agents) != 1 || slots[agents["ada"]] != 3 {
+		t.Errorf("second seed added %d reservations, %d agents exist, want nothing added", added, len(agents))
+	}
+	if inserts := fake.Matching(`^INSERT`); len(inserts) != 0 {
+		t.Errorf("second seed inserted %q", inserts)
+	}
+
+	// a lowered capacity gives up the reservations above it
+	agent.Capacity = 1
+	added, err = repository.SeedAgent(ctx, agent)
+	if err != nil {
+		t.Fatalf("SeedAgent with less capacity failed: %v", err)
+	}
+	if added != 0 || slots[agents["ada"]] != 1 {
+		t.Errorf("lowering the capacity added %d reservations, the agent has %d, want 0 and 1",
+			added, slots[agents["ada"]])
+	}
+}
+
+func TestReleaseReservationReportsMissingReservations(t *testing.T) {
+	tests := []struct {
+		name         string
+		answer       dbtest.Result
+		wantNotFound bool
+	}{
+		{name: "not held", answer: dbtest.Result{Columns: []string{"id"}}, wantNotFound: true},
+		{name: "database down", answer: dbtest.Result{Err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _, _ := fakeFleet(t)
+			fake.On(`^select \* from delivery_agent_reservations`, func(query dbtest.Query) dbtest.Result {
+				return tt.answer
+			})
+			err := (&DeliveryAgentRepository{}).ReleaseReservation(context.Background(), 7, "order-1")
+			if errors.Is(err, ErrReservationNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("ReleaseReservation = %v, want not found %t", err, tt.wantNotFound)
+			}
+		})
+	}
+}
+
+func TestCancelBookingReportsMissingBookings(t *testing.T) {
+	tests := []struct {
+		name         string
+		answer       dbtest.Result
+		wantNotFound bool
+	}{
+		{name: "not booked", answer: dbtest.Result{Columns: []string{"id"}}, wantNotFound: true},
+		{name: "database down", answer: dbtest.Result{Err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _, _ := fakeFleet(t)
+			fake.On(`^select \* from delivery_agent_reservations`, func(query dbtest.Query) dbtest.Result {
+				return tt.answer
+			})
+			err := (&DeliveryAgentRepository{}).CancelBooking(context.Background(), 7, "order-1")
+			if errors.Is(err, ErrBookingNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("CancelBooking = %v, want not found %t", err, tt.wantNotFound)
+			}
+		})
+	}
+}
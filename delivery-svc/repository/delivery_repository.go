@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/delivery-svc/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrInvalidTransition is returned when a delivery cannot move to the
+	// state asked for from the state it is in.
+	ErrInvalidTransition = errors.New("invalid delivery transition")
+	// ErrDeliveryUnderway is returned when the booking of a delivery that
+	// was already picked up is cancelled.
+	ErrDeliveryUnderway = errors.New("delivery is already under way")
+)
+
+type DeliveryRepository struct {
+}
+
+// createDelivery starts the delivery of orderID with the booked
+// reservation.
+func createDelivery(tx *gorm.DB, orderID string, reservation models.DeliveryAgentReservation) error {
+	delivery := models.Delivery{
+		OrderID:                    orderID,
+		DeliveryAgentID:            reservation.DeliveryAgentID,
+		DeliveryAgentReservationID: reservation.ID,
+		State:                      models.DeliveryAssigned,
+	}
+	if err := tx.Create(&delivery).Error; err != nil {
+		return err
+	}
+	return tx.Create(&models.DeliveryTransition{
+		DeliveryID: delivery.ID,
+		ToState:    models.DeliveryAssigned,
+		Reason:     "agent booked",
+	}).Error
+}
+
+// lockDelivery returns the delivery of orderID, locked until tx
+// ends, or ErrDeliveryNotFound.
+func lockDelivery(tx *gorm.DB, orderID string) (*models.Delivery, error) {
+	var delivery models.Delivery
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ?", orderID).First(&delivery).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// transition moves a delivery locked by tx to state and records it. A
+// delivery that is over gives its reservation back to the agent.
+func transition(tx *gorm.DB, delivery *models.Delivery, state string, reason string) error {
+	if !models.CanTransition(delivery.State, state) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, delivery.State, state)
+	}
+	from := delivery.State
+	if err := tx.Model(delivery).Update("state", state).Error; err != nil {
+		return err
+	}
+	err := tx.Create(&models.DeliveryTransition{
+		DeliveryID: delivery.ID,
+		FromState:  from,
+		ToState:    state,
+		Reason:     reason,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if !models.IsTerminalDeliveryState(state) {
+		return nil
+	}
+	return tx.Exec(`update delivery_agent_reservations
+			set is_reserved = false, current_order_id = null,
+				reserved_at = null, expires_at = null, holder = null
+			where id = ? and current_order_id = ?`,
+		delivery.DeliveryAgentReservationID, delivery.OrderID).Error
+}
+
+// GetDelivery returns the delivery of orderID with its transitions, oldest
+// first.
+func (r *DeliveryRepository) GetDelivery(ctx context.Context, orderID string) (*models.Delivery, error) {
+	ctx, span := tracer.Start(ctx, "GetDelivery: get_delivery on db")
+	defer span.End()
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	var delivery models.Delivery
+	txOut := client.WithContext(ctx).
+		Preload("Transitions", func(tx *gorm.DB) *gorm.DB {
+			return tx.Order("id")
+		}).
+		Where("order_id = ?", orderID).First(&delivery)
+	if txOut.Error == gorm.ErrRecordNotFound {
+		return nil, ErrDeliveryNotFound
+	}
+	if txOut.Error != nil {
+		span.SetStatus(codes.Error, txOut.Error.Error())
+		return nil, fmt.Errorf("failed to get delivery of order %s", orderID)
+	}
+	return &delivery, nil
+}
+
+// Transition moves the delivery of orderID to state, recording reason, and
+// frees the agent once the delivery is over.
+func (r *DeliveryRepository) Transition(ctx context.Context, orderID string,
+	state string, reason string) error {
+	ctx, span := tracer.Start(ctx, "Transition: transition_delivery on db")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("order.id", orderID),
+		attribute.String("delivery.state.to", state),
+	)
+
+	client, err := db.GetDBClient("delivery-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		delivery, err := lockDelivery(tx, orderID)
+		if err != nil {
+			return err
+		}
+		span.SetAttributes(
+			attribute.String("delivery.state.from", delivery.State),
+			attribute.Int("delivery_agent.id", delivery.DeliveryAgentID),
+		)
+		return transition(tx, delivery, state, reason)
+	})
+	if errors.Is(err, ErrDeliveryNotFound) || errors.Is(err, ErrInvalidTransition) {
+		return err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to move delivery of order %s to %s", orderID, state)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package fixtures
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Item is a catalog item of store-svc with the number of reservation slots
+// it should have.
+type Item struct {
+	Name  string `json:"name" yaml:"name"`
+	Stock int    `json:"stock" yaml:"stock"`
+}
+
+// Agent is a delivery agent of delivery-svc. Capacity defaults to 1 when it
+// is left out, a capacity of 0 keeps the agent without deliveries. The
+// shift, HH:MM times of day in UTC, defaults to the whole day.
+type Agent struct {
+	Name       string `json:"name" yaml:"name"`
+	Zone       string `json:"zone" yaml:"zone"`
+	Capacity   *int   `json:"capacity" yaml:"capacity"`
+	ShiftStart string `json:"shift_start" yaml:"shift_start"`
+	ShiftEnd   string `json:"shift_end" yaml:"shift_end"`
+}
+
+// Fixtures is the data a service is seeded with. Each service only applies
+// the part it owns.
+type Fixtures struct {
+	Items  []Item  `json:"items" yaml:"items"`
+	Agents []Agent `json:"agents" yaml:"agents"`
+}
+
+// Load reads fixtures from a .json, .yaml or .yml file.
+func Load(path string) (*Fixtures, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures: %w", err)
+	}
+	var fixtures Fixtures
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &fixtures)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &fixtures)
+	default:
+		return nil, fmt.Errorf("fixtures file %s must be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures %s: %w", path, err)
+	}
+	if err := fixtures.validate(); err != nil {
+		return nil, fmt.Errorf("invalid fixtures %s: %w", path, err)
+	}
+	return &fixtures, nil
+}
+
+func (f *Fixtures) validate() error {
+	names := make(map[string]bool, len(f.Items))
+	for _, item := range f.Items {
+		if item.Name == "" {
+			return fmt.Errorf("every item needs a name")
+		}
+		if names[item.Name] {
+			return fmt.Errorf("item %s is listed twice", item.Name)
+		}
+		names[item.Name] = true
+		if item.Stock < 0 {
+			return fmt.Errorf("stock of %s cannot be negative", item.Name)
+		}
+	}
+	agents := make(map[string]bool, len(f.Agents))
+	for i := range f.Agents {
+		agent := &f.Agents[i]
+		if agent.Name == "" {
+			return fmt.Errorf("every agent needs a name")
+		}
+		if agents[agent.Name] {
+			return fmt.Errorf("agent %s is listed twice", agent.Name)
+		}
+		agents[agent.Name] = true
+		if agent.Capacity == nil {
+			capacity := 1
+			agent.Capacity = &capacity
+		}
+		if *agent.Capacity < 0 {
+			return fmt.Errorf("capacity of %s cannot be negative", agent.Name)
+		}
+		if agent.ShiftStart == "" {
+			agent.ShiftStart = "00:00"
+		}
+		if agent.ShiftEnd == "" {
+			agent.ShiftEnd = "24:00"
+		}
+		if !validClock(agent.ShiftStart) || !validClock(agent.ShiftEnd) {
+			return fmt.Errorf("shift of %s must be given as HH:MM times", agent.Name)
+		}
+	}
+	return nil
+}
+
+// validClock reports whether clock is an HH:MM time of day, 24:00 being
+// the end of the day.
+func validClock(clock string) bool {
+	if clock == "24:00" {
+		return true
+	}
+	parsed, err := time.Parse("15:04", clock)
+	return err == nil && parsed.Format("15:04") == clock
+}
@@ -0,0 +1,129 @@
+package fixtures
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func capacity(n int) *int {
+	return &n
+}
+
+func TestLoad(t *testing.T) {
+	want := &Fixtures{
+		Items: []Item{{Name: "iPhone 12", Stock: 10}, {Name: "Pixel 7", Stock: 0}},
+		Agents: []Agent{
+			{Name: "ada", Zone: "berlin/mitte", Capacity: capacity(2), ShiftStart: "22:00", ShiftEnd: "06:00"},
+			{Name: "bob", Zone: "berlin", Capacity: capacity(1), ShiftStart: "00:00", ShiftEnd: "24:00"},
+			{Name: "eve", Zone: "berlin", Capacity: capacity(0), ShiftStart: "00:00", ShiftEnd: "24:00"},
+		},
+	}
+	tests := []struct {
+		file    string
+		content string
+	}{
+		{file: "fixtures.yaml", content: `
+items:
+  - name: iPhone 12
+    stock: 10
+  - name: Pixel 7
+agents:
+  - name: ada
+    zone: berlin/mitte
+    capacity: 2
+    shift_start: "22:00"
+    shift_end: "06:00"
+  - name: bob
+    zone: berlin
+  - name: eve
+    zone: berlin
+    capacity: 0
+`},
+		{file: "fixtures.YML", content: `
+items: [{name: iPhone 12, stock: 10}, {name: Pixel 7, stock: 0}]
+agents:
+  - {name: ada, zone: berlin/mitte, capacity: 2, shift_start: "22:00", shift_end: "06:00"}
+  - {name: bob, zone: berlin, capacity: 1, shift_start: "00:00", shift_end: "24:00"}
+  - {name: eve, zone: berlin, capacity: 0}
+`},
+		{file: "fixtures.json", content: `
+{"items": [{"name": "iPhone 12", "stock": 10}, {"name": "Pixel 7"}],
+ "agents": [
+  {"name": "ada", "zone": "berlin/mitte", "capacity": 2, "shift_start": "22:00", "shift_end": "06:00"},
+  {"name": "bob", "zone": "berlin"},
+  {"name": "eve", "zone": "berlin", "capacity": 0}
+ ]}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, err := Load(writeFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsInvalidFixtures(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown extension", file: "fixtures.toml", content: "agents = 1",
+			wantErr: "must be .json, .yaml or .yml"},
+		{name: "malformed", file: "fixtures.json", content: `{"items": [`,
+			wantErr: "failed to parse fixtures"},
+		{name: "unnamed item", file: "fixtures.yaml", content: "items: [{stock: 1}]",
+			wantErr: "every item needs a name"},
+		{name: "duplicate item", file: "fixtures.yaml",
+			content: "items: [{name: Pixel 7, stock: 1}, {name: Pixel 7, stock: 2}]",
+			wantErr: "item Pixel 7 is listed twice"},
+		{name: "negative stock", file: "fixtures.yaml", content: "items: [{name: Pixel 7, stock: -1}]",
+			wantErr: "stock of Pixel 7 cannot be negative"},
+		{name: "unnamed agent", file: "fixtures.yaml", content: "agents: [{zone: berlin}]",
+			wantErr: "every agent needs a name"},
+		{name: "duplicate agent", file: "fixtures.yaml", content: "agents: [{name: ada}, {name: ada}]",
+			wantErr: "agent ada is listed twice"},
+		{name: "negative capacity", file: "fixtures.yaml", content: "agents: [{name: ada, capacity: -1}]",
+			wantErr: "capacity of ada cannot be negative"},
+		{name: "shift without minutes", file: "fixtures.yaml",
+			content: `agents: [{name: ada, shift_start: "8", shift_end: "16:00"}]`,
+			wantErr: "shift of ada must be given as HH:MM times"},
+		{name: "shift past midnight", file: "fixtures.yaml",
+			content: `agents: [{name: ada, shift_start: "08:00", shift_end: "24:30"}]`,
+			wantErr: "shift of ada must be given as HH:MM times"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, tt.file, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil || !strings.Contains(err.Error(), "failed to read fixtures") {
+		t.Errorf("Load = %v, want the read error", err)
+	}
+}
@@ -0,0 +1,226 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// HeaderKey is the request header carrying the idempotency key.
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed is set on responses replayed from a stored record.
+	HeaderReplayed = "Idempotent-Replayed"
+)
+
+const (
+	// DefaultRetention is used when a Store has no Retention.
+	DefaultRetention = 24 * time.Hour
+	// DefaultLease is used when a Store has no Lease.
+	DefaultLease = time.Minute
+)
+
+// Store keeps the responses of requests made with an Idempotency-Key in the
+// database of ServiceName, so that a retried request gets the original
+// response instead of being run again.
+type Store struct {
+	ServiceName string
+	Retention   time.Duration
+	// Lease is how long a request keeps its key while it runs. A retry after
+	// the lease runs the request again, so that a request lost in a crash
+	// does not hold its key for the whole Retention. It must be longer than
+	// any request takes.
+	Lease time.Duration
+}
+
+func (s *Store) retention() time.Duration {
+	if s.Retention <= 0 {
+		return DefaultRetention
+	}
+	return s.Retention
+}
+
+func (s *Store) lease() time.Duration {
+	if s.Lease <= 0 {
+		return DefaultLease
+	}
+	return s.Lease
+}
+
+// takeOver hands record over to a retry of its request once the request
+// that created it has lost its lease without storing a response.
+func (s *Store) takeOver(client *gorm.DB, record *Record, now time.Time) (bool, error) {
+	txOut := client.Model(&Record{}).
+		Where("service = ? and method = ? and path = ? and key = ?",
+			record.Service, record.Method, record.Path, record.Key).
+		Where("request_hash = ? and status_code = 0", record.RequestHash).
+		Where("locked_until is null or locked_until < ?", now).
+		Updates(map[string]any{
+			"locked_until": record.LockedUntil,
+			"expires_at":   record.ExpiresAt,
+		})
+	if txOut.Error != nil {
+		return false, txOut.Error
+	}
+	if txOut.RowsAffected == 0 {
+		return false, nil
+	}
+	return true, client.
+		Where("service = ? and method = ? and path = ? and key = ?",
+			record.Service, record.Method, record.Path, record.Key).
+		First(record).Error
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+// Middleware runs a request with an Idempotency-Key at most once per key and
+// replays the stored response for every retry within the retention window.
+// A retry with a different body is rejected, as is one that arrives while
+// the first request is still running, unless its Lease has run out. Server
+// errors are not stored, so the request can be retried with the same key.
+func (s *Store) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.Respond(w, http.StatusBadRequest, map[string]any{
+				"error": "failed to read request body",
+			})
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		hash := sha256.Sum256(data)
+
+		now := time.Now()
+		client, err := db.GetDBClient(s.ServiceName)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to get the idempotency key store", "error", err)
+			utils.Respond(w, http.StatusInternalServerError, map[string]any{
+				"error": "failed to store idempotency key",
+			})
+			return
+		}
+		client = client.WithContext(r.Context())
+		client.Where("expires_at < ?", now).Delete(&Record{})
+
+		record := Record{
+			Service:     s.ServiceName,
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash[:]),
+			ExpiresAt:   now.Add(s.retention()),
+			LockedUntil: sql.NullTime{Time: now.Add(s.lease()), Valid: true},
+		}
+		txOut := client.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		owned := txOut.RowsAffected > 0
+		err = txOut.Error
+		if err == nil && !owned {
+			owned, err = s.takeOver(client, &record, now)
+			if owned {
+				slog.WarnContext(r.Context(), "took over an idempotency key whose lease ran out",
+					"key", key)
+			}
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to store idempotency key", "error", err)
+			utils.Respond(w, http.StatusInternalServerError, map[string]any{
+				"error": "failed to store idempotency key",
+			})
+			return
+		}
+		if !owned {
+			s.replay(w, client, record)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode == 0 || recorder.statusCode >= http.StatusInternalServerError {
+			client.Delete(&record)
+			return
+		}
+		err = client.Model(&record).Updates(map[string]any{
+			"status_code":  recorder.statusCode,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"body":         recorder.body.Bytes(),
+			"locked_until": nil,
+		}).Error
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to store idempotent response", "error", err)
+		}
+	})
+}
+
+// replay answers a retried request from the record stored by the first one.
+func (s *Store) replay(w http.ResponseWriter, client *gorm.DB, record Record) {
+	var stored Record
+	err := client.
+		Where("service = ? and method = ? and path = ? and key = ?",
+			record.Service, record.Method, record.Path, record.Key).
+		First(&stored).Error
+	if err != nil {
+		utils.Respond(w, http.StatusConflict, map[string]any{
+			"error": "idempotency key is being reused, retry the request",
+		})
+		return
+	}
+	if stored.RequestHash != record.RequestHash {
+		utils.Respond(w, http.StatusUnprocessableEntity, map[string]any{
+			"error": "idempotency key was already used with a different request",
+		})
+		return
+	}
+	if stored.StatusCode == 0 {
+		if stored.LockedUntil.Valid {
+			retryAfter := time.Until(stored.LockedUntil.Time).Seconds()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(max(retryAfter, 1)))))
+		}
+		utils.Respond(w, http.StatusConflict, map[string]any{
+			"error": "a request with this idempotency key is still in progress",
+		})
+		return
+	}
+	if stored.ContentType != "" {
+		w.Header().Set("Content-Type", stored.ContentType)
+	}
+	w.Header().Set(HeaderReplayed, "true")
+	w.WriteHeader(stored.StatusCode)
+	w.Write(stored.Body)
+}
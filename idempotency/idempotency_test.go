@@ -0,0 +1,195 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/db/dbtest"
+)
+
+// fakeRecords registers a fake database for the service "test-svc" keeping
+// idempotency records by key.
+func fakeRecords(t *testing.T) map[string]*Record {
+	fake := dbtest.New(t)
+	if err := db.Register("test-svc", fake.Gorm()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	records := make(map[string]*Record)
+	byID := func(id driver.Value) *Record {
+		for _, record := range records {
+			if int64(record.ID) == id.(int64) {
+				return record
+			}
+		}
+		return nil
+	}
+	fake.On(`^DELETE FROM "idempotency_records" WHERE expires_at < \$1`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+	fake.On(`^INSERT INTO "idempotency_records" .* ON CONFLICT DO NOTHING`, func(query dbtest.Query) dbtest.Result {
+		row := query.Rows()[0]
+		key := row["key"].(string)
+		if _, ok := records[key]; ok {
+			return dbtest.Result{Columns: []string{"id"}}
+		}
+		records[key] = &Record{
+			ID:          uint(len(records) + 1),
+			Key:         key,
+			RequestHash: row["request_hash"].(string),
+			LockedUntil: sql.NullTime{Time: row["locked_until"].(time.Time), Valid: true},
+		}
+		return dbtest.Rows("id", int64(records[key].ID))
+	})
+	fake.On(`^UPDATE "idempotency_records" SET "expires_at"=\$1,"locked_until"=\$2 WHERE`, func(query dbtest.Query) dbtest.Result {
+		record, ok := records[query.Args[5].(string)]
+		if !ok || record.RequestHash != query.Args[6] || record.StatusCode != 0 ||
+			record.LockedUntil.Valid && !record.LockedUntil.Time.Before(query.Args[7].(time.Time)) {
+			return dbtest.Result{}
+		}
+		record.LockedUntil = sql.NullTime{Time: query.Args[1].(time.Time), Valid: true}
+		return dbtest.Result{RowsAffected: 1}
+	})
+	fake.On(`^SELECT \* FROM "idempotency_records" WHERE service = \$1`, func(query dbtest.Query) dbtest.Result {
+		columns := []string{"id", "key", "request_hash", "status_code", "content_type", "body", "locked_until"}
+		record, ok := records[query.Args[3].(string)]
+		if !ok {
+			return dbtest.Result{Columns: columns}
+		}
+		var lockedUntil driver.Value
+		if record.LockedUntil.Valid {
+			lockedUntil = record.LockedUntil.Time
+		}
+		return dbtest.Result{Columns: columns, Rows: [][]driver.Value{{
+			int64(record.ID), record.Key, record.RequestHash, int64(record.StatusCode),
+			record.ContentType, record.Body, lockedUntil,
+		}}}
+	})
+	fake.On(`^UPDATE "idempotency_records" SET "body"=\$1,"content_type"=\$2,"locked_until"=\$3,"status_code"=\$4 WHERE "id" = \$5`, func(query dbtest.Query) dbtest.Result {
+		record := byID(query.Args[4])
+		record.Body = query.Args[0].([]byte)
+		record.ContentType = query.Args[1].(string)
+		record.LockedUntil = sql.NullTime{}
+		record.StatusCode = int(query.Args[3].(int64))
+		return dbtest.Result{RowsAffected: 1}
+	})
+	fake.On(`^DELETE FROM "idempotency_records" WHERE "idempotency_records"."id" = \$1`, func(query dbtest.Query) dbtest.Result {
+		if record := byID(query.Args[0]); record != nil {
+			delete(records, record.Key)
+		}
+		return dbtest.Result{RowsAffected: 1}
+	})
+	return records
+}
+
+// countingHandler answers every request with the next of statuses and
+// counts the requests it ran.
+func countingHandler(calls *int, statuses ...int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[min(*calls, len(statuses)-1)]
+		*calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"order_id":"order-1"}`))
+	})
+}
+
+func send(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/order", strings.NewReader(body))
+	request.Header.Set(HeaderKey, key)
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func requestHash(body string) string {
+	hash := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(hash[:])
+}
+
+func TestMiddlewareReplaysStoredResponse(t *testing.T) {
+	fakeRecords(t)
+	var calls int
+	handler := (&Store{ServiceName: "test-svc"}).Middleware(countingHandler(&calls, http.StatusCreated))
+
+	first := send(handler, "key-1", `{"item_id": 1}`)
+	retry := send(handler, "key-1", `{"item_id": 1}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+		t.Errorf("retry got %d %q, want the original %d %q",
+			retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+	if retry.Header().Get(HeaderReplayed) != "true" || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("retry headers are %v, want a replayed JSON response", retry.Header())
+	}
+
+	if other := send(handler, "key-1", `{"item_id": 2}`); other.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reusing the key for another request got %d, want %d",
+			other.Code, http.StatusUnprocessableEntity)
+	}
+	if calls != 1 {
+		t.Errorf("handler ran %d times, want once", calls)
+	}
+}
+
+func TestMiddlewareDoesNotStoreServerErrors(t *testing.T) {
+	records := fakeRecords(t)
+	var calls int
+	handler := (&Store{ServiceName: "test-svc"}).Middleware(
+		countingHandler(&calls, http.StatusInternalServerError, http.StatusOK))
+
+	if first := send(handler, "key-1", `{}`); first.Code != http.StatusInternalServerError {
+		t.Fatalf("first request got %d, want %d", first.Code, http.StatusInternalServerError)
+	}
+	if _, ok := records["key-1"]; ok {
+		t.Errorf("the key of a failed request is still held")
+	}
+
+	retry := send(handler, "key-1", `{}`)
+	if calls != 2 || retry.Code != http.StatusOK || retry.Header().Get(HeaderReplayed) != "" {
+		t.Errorf("retry got %d after %d runs, want the request run again", retry.Code, calls)
+	}
+	if record, ok := records["key-1"]; !ok || record.StatusCode != http.StatusOK {
+		t.Errorf("the response of the retry was not stored")
+	}
+}
+
+func TestMiddlewareTakesOverKeyAfterLease(t *testing.T) {
+	records := fakeRecords(t)
+	var calls int
+	handler := (&Store{ServiceName: "test-svc"}).Middleware(countingHandler(&calls, http.StatusOK))
+
+	// the first request holds its lease
+	records["key-1"] = &Record{
+		ID:          1,
+		Key:         "key-1",
+		RequestHash: requestHash(`{}`),
+		LockedUntil: sql.NullTime{Time: time.Now().Add(time.Minute), Valid: true},
+	}
+	retry := send(handler, "key-1", `{}`)
+	if calls != 0 || retry.Code != http.StatusConflict || retry.Header().Get("Retry-After") == "" {
+		t.Fatalf("retry during the lease got %d with Retry-After %q after %d runs, want 409 with Retry-After",
+			retry.Code, retry.Header().Get("Retry-After"), calls)
+	}
+
+	// the first request was lost and its lease ran out
+	records["key-1"].LockedUntil.Time = time.Now().Add(-time.Second)
+	retry = send(handler, "key-1", `{}`)
+	if calls != 1 || retry.Code != http.StatusOK {
+		t.Fatalf("retry after the lease got %d after %d runs, want the request run", retry.Code, calls)
+	}
+	if record := records["key-1"]; record.StatusCode != http.StatusOK || record.LockedUntil.Valid {
+		t.Errorf("record after the takeover is %+v, want the response stored and the lease given up", record)
+	}
+}
@@ -0,0 +1,55 @@
+package idempotency
+
+import (
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+	"gorm.io/gorm"
+)
+
+// Migration creates the table of Record as version of the migrations of
+// serviceName. Services sharing a database share the table, so reverting it
+// only deletes the records of serviceName.
+func Migration(version int64, serviceName string) migrate.Migration {
+	return migrate.Migration{
+		Version: version,
+		Name:    "create_idempotency_records",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(`create table if not exists idempotency_records (
+					id bigserial primary key,
+					service text not null,
+					method text not null,
+					path text not null,
+					key text not null,
+					request_hash text not null,
+					status_code bigint,
+					content_type text,
+					body bytea,
+					created_at timestamptz,
+					expires_at timestamptz not null
+				);
+				create unique index if not exists idx_idempotency_records_key
+					on idempotency_records (service, method, path, key);
+				create index if not exists idx_idempotency_records_expires_at
+					on idempotency_records (expires_at)`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec(`delete from idempotency_records where service = ?`, serviceName).Error
+		},
+	}
+}
+
+// LeaseMigration adds the lease of running requests to the table of Record
+// as version of the migrations of a service. Reverting it keeps the column,
+// which other services sharing the database may still use.
+func LeaseMigration(version int64) migrate.Migration {
+	return migrate.Migration{
+		Version: version,
+		Name:    "add_idempotency_lease",
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(`alter table idempotency_records
+				add column if not exists locked_until timestamptz`).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
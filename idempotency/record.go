@@ -0,0 +1,30 @@
+package idempotency
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Record is the stored outcome of a request made with an Idempotency-Key. A
+// record with a zero StatusCode belongs to a request that is still running,
+// or that never finished if LockedUntil has passed.
+type Record struct {
+	ID          uint   `gorm:"primarykey"`
+	Service     string `gorm:"uniqueIndex:idx_idempotency_records_key;not null"`
+	Method      string `gorm:"uniqueIndex:idx_idempotency_records_key;not null"`
+	Path        string `gorm:"uniqueIndex:idx_idempotency_records_key;not null"`
+	Key         string `gorm:"uniqueIndex:idx_idempotency_records_key;not null"`
+	RequestHash string `gorm:"not null"`
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time `gorm:"index;not null"`
+	// LockedUntil is when the request running for the record gives up its
+	// lease, after which a retry may take the record over.
+	LockedUntil sql.NullTime
+}
+
+func (Record) TableName() string {
+	return "idempotency_records"
+}
@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config describes the logger of a service.
+type Config struct {
+	ServiceName string
+	// Level is the lowest level logged, slog.LevelInfo by default.
+	Level slog.Level
+	// SpanEvents mirrors every record as an event on the span in its
+	// context.
+	SpanEvents bool
+}
+
+// ConfigFromEnv builds the Config of serviceName from LOG_LEVEL (debug,
+// info, warn or error) and LOG_SPAN_EVENTS.
+func ConfigFromEnv(serviceName string) Config {
+	var level slog.Level
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			level = slog.LevelInfo
+		}
+	}
+	return Config{
+		ServiceName: serviceName,
+		Level:       level,
+		SpanEvents:  os.Getenv("LOG_SPAN_EVENTS") == "true",
+	}
+}
+
+// Init installs a JSON logger for the service described by cfg as the
+// default slog logger, which the standard log package also writes through.
+// Records logged with a context carry its trace_id, span_id and order_id.
+func Init(cfg Config) *slog.Logger {
+	logger := slog.New(&contextHandler{
+		Handler: slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Level}).
+			WithAttrs([]slog.Attr{slog.String("service", cfg.ServiceName)}),
+		spanEvents: cfg.SpanEvents,
+	})
+	slog.SetDefault(logger)
+	return logger
+}
+
+// contextHandler adds the trace and order of the context to every record.
+type contextHandler struct {
+	slog.Handler
+	spanEvents bool
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	span := trace.SpanFromContext(ctx)
+	if h.spanEvents && span.IsRecording() {
+		attributes := []attribute.KeyValue{
+			attribute.String("log.severity", record.Level.String()),
+		}
+		record.Attrs(func(attr slog.Attr) bool {
+			attributes = append(attributes, attribute.String(attr.Key, attr.Value.String()))
+			return true
+		})
+		span.AddEvent(record.Message, trace.WithAttributes(attributes...))
+	}
+	if spanContext := span.SpanContext(); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	if orderID := distributedTracer.BaggageValue(ctx, distributedTracer.BaggageOrderID); orderID != "" {
+		record.AddAttrs(slog.String("order_id", orderID))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs), spanEvents: h.spanEvents}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name), spanEvents: h.spanEvents}
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	otelmetric "go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// Init installs a MeterProvider for serviceName as the global one and
+// returns the handler that serves its metrics in the Prometheus format,
+// meant to be mounted on /metrics.
+func Init(serviceName string) (http.Handler, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprometheus.New(otelprometheus.WithRegisterer(registry))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := metric.NewMeterProvider(
+		metric.WithResource(res),
+		metric.WithReader(exporter),
+	)
+	otel.SetMeterProvider(provider)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}), nil
+}
+
+// ObserveGauge registers an int64 gauge that is read with observe whenever
+// metrics are collected. observe reports one value per attribute set.
+func ObserveGauge(meterName string, name string, description string,
+	observe func(ctx context.Context, report func(value int64, attributes ...attribute.KeyValue)) error) error {
+	_, err := otel.Meter(meterName).Int64ObservableGauge(name,
+		otelmetric.WithDescription(description),
+		otelmetric.WithInt64Callback(func(ctx context.Context, observer otelmetric.Int64Observer) error {
+			return observe(ctx, func(value int64, attributes ...attribute.KeyValue) {
+				observer.Observe(value, otelmetric.WithAttributes(attributes...))
+			})
+		}))
+	return err
+}
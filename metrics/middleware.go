@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const instrumentationName = "github.com/Roy19/distributed-transaction-2pc/metrics"
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+// Middleware records the rate, errors and duration of every request per
+// chi route. Every request is counted in http.server.request.duration,
+// requests answered with a 5xx status are also counted in
+// http.server.request.errors.
+func Middleware(next http.Handler) http.Handler {
+	meter := otel.Meter(instrumentationName)
+	duration, err := meter.Float64Histogram("http.server.request.duration",
+		metric.WithDescription("Duration of HTTP server requests."),
+		metric.WithUnit("s"))
+	if err != nil {
+		slog.Error("failed to create the request duration histogram", "error", err)
+	}
+	errors, err := meter.Int64Counter("http.server.request.errors",
+		metric.WithDescription("Number of HTTP server requests answered with a 5xx status."),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		slog.Error("failed to create the request error counter", "error", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode == 0 {
+			recorder.statusCode = http.StatusOK
+		}
+		// unrouted requests share one route so that scanners cannot blow up
+		// the number of series
+		route := "unmatched"
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			if pattern := routeContext.RoutePattern(); pattern != "" {
+				route = pattern
+			}
+		}
+		attributes := metric.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.HTTPRoute(route),
+			semconv.HTTPResponseStatusCode(recorder.statusCode),
+		)
+		duration.Record(r.Context(), time.Since(start).Seconds(), attributes)
+		if recorder.statusCode >= http.StatusInternalServerError {
+			errors.Add(r.Context(), 1, attributes)
+		}
+	})
+}
@@ -0,0 +1,265 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is one numbered schema change. Up applies it and Down reverts
+// it, both run inside a transaction together with the update of
+// schema_migrations.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// SQL builds a Migration that runs the up and down statements. Several
+// statements can be separated by semicolons.
+func SQL(version int64, name string, up string, down string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec(up).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec(down).Error
+		},
+	}
+}
+
+// Status is whether a migration has been applied and when.
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt sql.NullTime
+}
+
+// Migrator applies the migrations of a service and records them in
+// schema_migrations under the service name, so that services sharing a
+// database keep separate histories. Every migration takes a transaction
+// scoped advisory lock on the service, so concurrent instances apply each
+// migration once.
+type Migrator struct {
+	DB         *gorm.DB
+	Service    string
+	Migrations []Migration
+}
+
+func (m *Migrator) sorted() ([]Migration, error) {
+	migrations := append([]Migration(nil), m.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %s has version %d, versions start at 1",
+				migration.Name, migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("migrations %s and %s share version %d",
+				migrations[i-1].Name, migration.Name, migration.Version)
+		}
+	}
+	return migrations, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	return m.DB.WithContext(ctx).Exec(`create table if not exists schema_migrations (
+			service text not null,
+			version bigint not null,
+			name text not null,
+			applied_at timestamptz not null default now(),
+			primary key (service, version)
+		)`).Error
+}
+
+func (m *Migrator) applied(tx *gorm.DB) (map[int64]time.Time, error) {
+	var rows []struct {
+		Version   int64
+		AppliedAt time.Time
+	}
+	err := tx.Raw(`select version, applied_at from schema_migrations
+		where service = ?`, m.Service).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+	return applied, nil
+}
+
+// locked runs fn in a transaction holding the advisory lock of the service.
+// fn gets the versions applied so far, read once the lock is held.
+func (m *Migrator) locked(ctx context.Context, fn func(tx *gorm.DB, applied map[int64]time.Time) error) error {
+	return m.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec("select pg_advisory_xact_lock(hashtext(?))",
+			"schema_migrations:"+m.Service).Error
+		if err != nil {
+			return fmt.Errorf("failed to lock schema_migrations: %w", err)
+		}
+		applied, err := m.applied(tx)
+		if err != nil {
+			return err
+		}
+		return fn(tx, applied)
+	})
+}
+
+// Up applies every pending migration in version order and returns how many
+// it applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return 0, err
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, migration := range migrations {
+		migration := migration
+		err := m.locked(ctx, func(tx *gorm.DB, applied map[int64]time.Time) error {
+			if _, ok := applied[migration.Version]; ok {
+				return nil
+			}
+			if err := migration.Up(tx); err != nil {
+				return fmt.Errorf("failed to apply migration %d %s: %w",
+					migration.Version, migration.Name, err)
+			}
+			count++
+			return tx.Exec(`insert into schema_migrations (service, version, name)
+				values (?, ?, ?)`, m.Service, migration.Version, migration.Name).Error
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and
+// returns how many it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return 0, err
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	byVersion := make(map[int64]Migration, len(migrations))
+	for _, migration := range migrations {
+		byVersion[migration.Version] = migration
+	}
+	count := 0
+	for count < steps {
+		reverted := false
+		err := m.locked(ctx, func(tx *gorm.DB, applied map[int64]time.Time) error {
+			if len(applied) == 0 {
+				return nil
+			}
+			var last int64
+			for version := range applied {
+				if version > last {
+					last = version
+				}
+			}
+			migration, ok := byVersion[last]
+			if !ok {
+				return fmt.Errorf("applied migration %d is unknown to %s", last, m.Service)
+			}
+			if err := migration.Down(tx); err != nil {
+				return fmt.Errorf("failed to revert migration %d %s: %w",
+					migration.Version, migration.Name, err)
+			}
+			reverted = true
+			return tx.Exec(`delete from schema_migrations where service = ? and version = ?`,
+				m.Service, migration.Version).Error
+		})
+		if err != nil {
+			return count, err
+		}
+		if !reverted {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status lists every known migration in version order with the time it was
+// applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	migrations, err := m.sorted()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(m.DB.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(migrations))
+	for _, migration := range migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.AppliedAt = sql.NullTime{Time: appliedAt, Valid: true}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Run executes the migrate subcommand given by args: "up", "down [steps]"
+// with one step by default, or "status". It reports to out.
+func (m *Migrator) Run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: migrate up | down [steps] | status")
+	}
+	switch args[0] {
+	case "up":
+		count, err := m.Up(ctx)
+		fmt.Fprintf(out, "applied %d migrations\n", count)
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+		count, err := m.Down(ctx, steps)
+		fmt.Fprintf(out, "reverted %d migrations\n", count)
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt.Valid {
+				applied = status.AppliedAt.Time.Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%4d  %-40s %s\n", status.Version, status.Name, applied)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %s, use up, down or status", args[0])
+}
@@ -0,0 +1,298 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/db/dbtest"
+	"gorm.io/gorm"
+)
+
+// fakeSchema answers the statements of a Migrator on schema_migrations from
+// the versions in applied.
+func fakeSchema(t *testing.T, applied ...int64) *dbtest.DB {
+	fake := dbtest.New(t)
+	versions := make(map[int64]bool)
+	for _, version := range applied {
+		versions[version] = true
+	}
+	appliedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	fake.On(`^create table if not exists schema_migrations`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+	fake.On(`^select pg_advisory_xact_lock`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+	fake.On(`^select version, applied_at from schema_migrations`, func(query dbtest.Query) dbtest.Result {
+		result := dbtest.Result{Columns: []string{"version", "applied_at"}}
+		for version := range versions {
+			result.Rows = append(result.Rows, []driver.Value{version, appliedAt})
+		}
+		return result
+	})
+	fake.On(`^insert into schema_migrations`, func(query dbtest.Query) dbtest.Result {
+		versions[query.Args[1].(int64)] = true
+		return dbtest.Result{RowsAffected: 1}
+	})
+	fake.On(`^delete from schema_migrations`, func(query dbtest.Query) dbtest.Result {
+		delete(versions, query.Args[1].(int64))
+		return dbtest.Result{RowsAffected: 1}
+	})
+	return fake
+}
+
+// step is a migration whose statements are "up <name>" and "down <name>".
+func step(version int64, name string) Migration {
+	return SQL(version, name, "up "+name, "down "+name)
+}
+
+func answerSteps(fake *dbtest.DB) {
+	fake.On(`^(up|down) `, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+}
+
+// steps returns the up and down statements that were run, in order.
+func steps(fake *dbtest.DB) []string {
+	return fake.Matching(`^(up|down) `)
+}
+
+func newMigrator(fake *dbtest.DB, migrations ...Migration) *Migrator {
+	return &Migrator{DB: fake.Gorm(), Service: "store-svc", Migrations: migrations}
+}
+
+func TestUp(t *testing.T) {
+	fake := fakeSchema(t, 1)
+	answerSteps(fake)
+	migrator := newMigrator(fake,
+		step(3, "add_index"), step(1, "create_items"), step(2, "add_price"))
+
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Up applied %d migrations, want 2", count)
+	}
+	if got, want := steps(fake), []string{"up add_price", "up add_index"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Up ran %q, want %q", got, want)
+	}
+
+	// every migration runs in its own transaction, holding the lock of
+	// the service from before the applied versions are read
+	log := fake.Log()
+	start := indexOf(log, "up add_price")
+	want := []string{
+		dbtest.Begin,
+		"select pg_advisory_xact_lock(hashtext($1))",
+		"select version, applied_at from schema_migrations where service = $1",
+		"up add_price",
+		"insert into schema_migrations (service, version, name) values ($1, $2, $3)",
+		dbtest.Commit,
+	}
+	if start < 3 || !reflect.DeepEqual(log[start-3:start+3], want) {
+		t.Errorf("Up ran\n%s\nwant the migration within\n%s",
+			strings.Join(log, "\n"), strings.Join(want, "\n"))
+	}
+
+	fake.Reset()
+	count, err = migrator.Up(context.Background())
+	if err != nil || count != 0 {
+		t.Errorf("Up again = %d, %v, want nothing to apply", count, err)
+	}
+	if len(steps(fake)) != 0 {
+		t.Errorf("Up again ran %q", steps(fake))
+	}
+}
+
+func TestUpStopsAtFailedMigration(t *testing.T) {
+	fake := fakeSchema(t)
+	answerSteps(fake)
+	fake.On(`^up add_price$`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{Err: errors.New("column price already exists")}
+	})
+	migrator := newMigrator(fake,
+		step(1, "create_items"), step(2, "add_price"), step(3, "add_index"))
+
+	count, err := migrator.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "failed to apply migration 2 add_price") {
+		t.Fatalf("Up = %v, want the failure of add_price", err)
+	}
+	if count != 1 {
+		t.Errorf("Up applied %d migrations, want 1", count)
+	}
+	if got, want := steps(fake), []string{"up create_items", "up add_price"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Up ran %q, want %q", got, want)
+	}
+	if inserted := fake.Matching(`^insert into schema_migrations`); len(inserted) != 1 {
+		t.Errorf("Up recorded %d migrations, want 1", len(inserted))
+	}
+	if log := fake.Log(); log[len(log)-1] != dbtest.Rollback {
+		t.Errorf("the failed migration should be rolled back, got %q", log[len(log)-1])
+	}
+}
+
+func TestDown(t *testing.T) {
+	tests := []struct {
+		name      string
+		applied   []int64
+		steps     int
+		wantCount int
+		wantSteps []string
+		wantErr   string
+	}{
+		{name: "one step", applied: []int64{1, 2, 3}, steps: 1, wantCount: 1,
+			wantSteps: []string{"down add_index"}},
+		{name: "newest first", applied: []int64{1, 2, 3}, steps: 2, wantCount: 2,
+			wantSteps: []string{"down add_index", "down add_price"}},
+		{name: "more steps than applied", applied: []int64{1, 2}, steps: 5, wantCount: 2,
+			wantSteps: []string{"down add_price", "down create_items"}},
+		{name: "nothing applied", steps: 1},
+		{name: "unknown version", applied: []int64{1, 9}, steps: 1,
+			wantErr: "applied migration 9 is unknown to store-svc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeSchema(t, tt.applied...)
+			answerSteps(fake)
+			migrator := newMigrator(fake,
+				step(1, "create_items"), step(2, "add_price"), step(3, "add_index"))
+
+			count, err := migrator.Down(context.Background(), tt.steps)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Down = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Down failed: %v", err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("Down reverted %d migrations, want %d", count, tt.wantCount)
+			}
+			if got := steps(fake); !reflect.DeepEqual(got, tt.wantSteps) {
+				t.Errorf("Down ran %q, want %q", got, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestInvalidMigrations(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []Migration
+		wantErr    string
+	}{
+		{name: "version zero", migrations: []Migration{step(0, "create_items")},
+			wantErr: "migration create_items has version 0, versions start at 1"},
+		{name: "shared version", migrations: []Migration{step(1, "create_items"), step(1, "add_price")},
+			wantErr: "migrations create_items and add_price share version 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := dbtest.New(t)
+			migrator := newMigrator(fake, tt.migrations...)
+			runs := map[string]func() error{
+				"up": func() error {
+					_, err := migrator.Up(context.Background())
+					return err
+				},
+				"down": func() error {
+					_, err := migrator.Down(context.Background(), 1)
+					return err
+				},
+				"status": func() error {
+					_, err := migrator.Status(context.Background())
+					return err
+				},
+			}
+			for name, run := range runs {
+				if err := run(); err == nil || err.Error() != tt.wantErr {
+					t.Errorf("%s = %v, want %s", name, err, tt.wantErr)
+				}
+			}
+			if log := fake.Log(); len(log) != 0 {
+				t.Errorf("invalid migrations should not touch the database, ran %q", log)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{args: nil, wantErr: "usage: migrate up | down [steps] | status"},
+		{args: []string{"up"}, want: "applied 1 migrations\n"},
+		{args: []string{"down"}, want: "reverted 1 migrations\n"},
+		{args: []string{"down", "2"}, want: "reverted 1 migrations\n"},
+		{args: []string{"down", "0"}, wantErr: `steps must be a positive number, got "0"`},
+		{args: []string{"down", "all"}, wantErr: `steps must be a positive number, got "all"`},
+		{args: []string{"status"},
+			want: "   1  create_items                             2024-05-01T12:00:00Z\n" +
+				"   2  add_price                                pending\n"},
+		{args: []string{"sideways"}, wantErr: "unknown migrate command sideways, use up, down or status"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			fake := fakeSchema(t, 1)
+			answerSteps(fake)
+			migrator := newMigrator(fake, step(1, "create_items"), step(2, "add_price"))
+
+			var out bytes.Buffer
+			err := migrator.Run(context.Background(), tt.args, &out)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Run(%q) = %v, want %s", tt.args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run(%q) failed: %v", tt.args, err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Run(%q) printed %q, want %q", tt.args, out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationFunctions(t *testing.T) {
+	fake := fakeSchema(t)
+	var ran []string
+	record := func(name string) func(tx *gorm.DB) error {
+		return func(tx *gorm.DB) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	migrator := newMigrator(fake,
+		Migration{Version: 1, Name: "backfill", Up: record("up"), Down: record("down")})
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if _, err := migrator.Down(context.Background(), 1); err != nil {
+		t.Fatalf("Down failed: %v", err)
+	}
+	if want := []string{"up", "down"}; !reflect.DeepEqual(ran, want) {
+		t.Errorf("ran %q, want %q", ran, want)
+	}
+}
+
+func indexOf(log []string, statement string) int {
+	for i, entry := range log {
+		if entry == statement {
+			return i
+		}
+	}
+	return -1
+}
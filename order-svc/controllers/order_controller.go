@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/dto"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/repository"
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/order-svc/controllers")
+
+type OrderController struct {
+	OrderRepository *repository.OrderRepository
+}
+
+func toOrderDto(order models.Order, reservations map[string]int64) dto.OrderDto {
+	if reservations == nil {
+		reservations = map[string]int64{}
+	}
+	items := make([]dto.OrderLineDto, 0, len(order.Lines))
+	for _, line := range order.Lines {
+		items = append(items, dto.OrderLineDto{ItemID: line.ItemID, Quantity: line.Quantity})
+	}
+	// orders placed before orders had lines hold a single unit of ItemID
+	if len(items) == 0 && order.ItemID != 0 {
+		items = append(items, dto.OrderLineDto{ItemID: order.ItemID, Quantity: 1})
+	}
+	return dto.OrderDto{
+		OrderID:      order.OrderID,
+		ItemID:       order.ItemID,
+		Items:        items,
+		DeliveryZone: order.DeliveryZone,
+		Mode:         order.Mode,
+		State:        order.State,
+		Reason:       order.Reason,
+		Reservations: reservations,
+		CreatedAt:    order.CreatedAt,
+		UpdatedAt:    order.UpdatedAt,
+	}
+}
+
+func (c *OrderController) GetOrder(ctx context.Context, orderID string) (*dto.OrderDto, error) {
+	ctx, span := tracer.Start(ctx, "OrderController.GetOrder: get_order")
+	defer span.End()
+
+	order, err := c.OrderRepository.GetOrder(ctx, orderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get order from db",
+			"order_id", orderID, "error", err)
+		return nil, err
+	}
+	reservations, err := c.OrderRepository.GetReservations(ctx, []string{orderID})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get reservations of order from db",
+			"order_id", orderID, "error", err)
+		return nil, err
+	}
+	orderDto := toOrderDto(*order, reservations[orderID])
+	return &orderDto, nil
+}
+
+func (c *OrderController) ListOrders(ctx context.Context, state string,
+	limit int, offset int) ([]dto.OrderDto, error) {
+	ctx, span := tracer.Start(ctx, "OrderController.ListOrders: list_orders")
+	defer span.End()
+
+	orders, err := c.OrderRepository.ListOrders(ctx, state, limit, offset)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list orders from db", "error", err)
+		return nil, err
+	}
+	orderIDs := make([]string, 0, len(orders))
+	for _, order := range orders {
+		orderIDs = append(orderIDs, order.OrderID)
+	}
+	reservations, err := c.OrderRepository.GetReservations(ctx, orderIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get reservations of orders from db", "error", err)
+		return nil, err
+	}
+	orderDtos := make([]dto.OrderDto, 0, len(orders))
+	for _, order := range orders {
+		orderDtos = append(orderDtos, toOrderDto(order, reservations[order.OrderID]))
+	}
+	return orderDtos, nil
+}
@@ -0,0 +1,511 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/config"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/order-svc/coordinator")
+
+const (
+	// DefaultPhaseTimeout is used when a Coordinator has no PhaseTimeout.
+	DefaultPhaseTimeout = config.DefaultPhaseTimeout
+	// DefaultRecoveryInterval is how often RunRecovery looks for
+	// transactions to recover by default.
+	DefaultRecoveryInterval = config.DefaultRecoveryInterval
+)
+
+var (
+	// ErrInDoubt is returned by CreateOrder for an order whose commit was
+	// logged but not yet acknowledged by every participant. The order ID is
+	// returned along with it, and recovery completes the order.
+	ErrInDoubt = errors.New("order is committing")
+	// ErrUnknownMode is returned by CreateOrder for an unknown transaction
+	// mode.
+	ErrUnknownMode = errors.New("unknown transaction mode")
+)
+
+// Coordinator drives a distributed transaction across its registered
+// participants, either as a two phase commit or as a saga. A saga visits
+// participants in registration order.
+type Coordinator struct {
+	TransactionLog TransactionLog
+	Orders         OrderStore
+	// Mode is the transaction mode used when a request does not pick one.
+	Mode string
+	// PhaseTimeout bounds how long the participants of a two phase commit
+	// get to answer in each phase.
+	PhaseTimeout time.Duration
+	// RecoverAfter is how long a transaction must go without progress
+	// before Recover takes it over from the instance that started it. It
+	// defaults to twice the PhaseTimeout.
+	RecoverAfter time.Duration
+	participants []Participant
+}
+
+func (c *Coordinator) phaseTimeout() time.Duration {
+	if c.PhaseTimeout <= 0 {
+		return DefaultPhaseTimeout
+	}
+	return c.PhaseTimeout
+}
+
+func (c *Coordinator) recoverAfter() time.Duration {
+	if c.RecoverAfter <= 0 {
+		return 2 * c.phaseTimeout()
+	}
+	return c.RecoverAfter
+}
+
+// withPhaseTimeout runs call with the phase deadline, so that every
+// participant call makes progress on the log within a PhaseTimeout.
+func (c *Coordinator) withPhaseTimeout(ctx context.Context, call func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, c.phaseTimeout())
+	defer cancel()
+	return call(ctx)
+}
+
+// setOrderState records the outcome on the customer facing order. The
+// transaction log stays the source of truth, so a failure is only logged.
+func (c *Coordinator) setOrderState(ctx context.Context, orderID string, state string, reason string) {
+	if err := c.Orders.SetState(ctx, orderID, state, reason); err != nil {
+		slog.ErrorContext(ctx, "failed to set order state", "state", state, "error", err)
+	}
+}
+
+// withOrder adds the order ID to the baggage sent to every participant.
+func withOrder(ctx context.Context, txn *models.GlobalTransaction) context.Context {
+	return distributedTracer.WithBaggage(ctx, distributedTracer.BaggageOrderID, txn.OrderID)
+}
+
+// begin creates the order and the transaction log entry for a new
+// transaction.
+func (c *Coordinator) begin(ctx context.Context, order Order, mode string) (*models.GlobalTransaction, error) {
+	orderID := uuid.New().String()
+	ctx = distributedTracer.WithBaggage(ctx, distributedTracer.BaggageOrderID, orderID)
+	if err := c.Orders.Create(ctx, orderID, order.Lines, order.DeliveryZone, mode); err != nil {
+		slog.ErrorContext(ctx, "failed to create order", "error", err)
+		return nil, err
+	}
+	txn, err := c.TransactionLog.Begin(ctx, orderID, order.Lines, order.DeliveryZone, mode)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to start transaction", "error", err)
+		c.setOrderState(ctx, orderID, models.OrderFailed, err.Error())
+		return nil, err
+	}
+	return txn, nil
+}
+
+func (c *Coordinator) Register(participant Participant) {
+	c.participants = append(c.participants, participant)
+}
+
+// logPrepared logs the reservation a participant prepared. When it cannot
+// be logged, the reservation is released right away since an abort would
+// not know about it.
+func (c *Coordinator) logPrepared(ctx context.Context, txn *models.GlobalTransaction,
+	participant Participant, reservationID int64) error {
+	err := c.TransactionLog.AddParticipant(ctx, txn, participant.Name(), reservationID)
+	if err == nil {
+		return nil
+	}
+	if abortErr := participant.Abort(ctx, txn, reservationID); abortErr != nil {
+		slog.ErrorContext(ctx, "failed to release unlogged reservation, it is left to expire",
+			"participant", participant.Name(), "reservation_id", reservationID, "error", abortErr)
+	}
+	return err
+}
+
+func (c *Coordinator) participant(name string) (Participant, error) {
+	for _, participant := range c.participants {
+		if participant.Name() == name {
+			return participant, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown participant %s", name)
+}
+
+// abort logs the abort decision and undoes every participant in reverse
+// order: prepared reservations are released and committed ones are
+// compensated. The transaction is only marked ABORTED once all of them have
+// been undone, and the order then ABORTED. A booking that can no longer be
+// compensated is left booked, the transaction is still marked ABORTED so
+// that it is not retried, but the order is FAILED for an operator to look
+// at.
+func (c *Coordinator) abort(ctx context.Context, txn *models.GlobalTransaction, reason string) error {
+	return c.undo(ctx, txn, models.OrderAborted, OutcomeAborted, reason)
+}
+
+// fail undoes a transaction that can no longer finish the way it was
+// decided, like abort, but leaves the order FAILED with reason for an
+// operator to look at. Recovery does not retry it.
+func (c *Coordinator) fail(ctx context.Context, txn *models.GlobalTransaction, reason string) error {
+	slog.ErrorContext(ctx, "order failed and needs attention", "reason", reason)
+	return c.undo(ctx, txn, models.OrderFailed, OutcomeFailed, reason)
+}
+
+// undo backs abort and fail, it sets the order to orderState and records
+// outcome once every participant has been undone or cannot be.
+func (c *Coordinator) undo(ctx context.Context, txn *models.GlobalTransaction,
+	orderState string, outcome string, reason string) error {
+	ctx, span := tracer.Start(ctx, "coordinator: abort")
+	defer span.End()
+	span.SetAttributes(attribute.String("transaction.mode", txn.Mode))
+	start := time.Now()
+
+	if err := c.TransactionLog.SetState(ctx, txn, models.TransactionAborting); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	var failed, stuck error
+	for i := len(txn.Participants) - 1; i >= 0; i-- {
+		record := &txn.Participants[i]
+		participant, err := c.participant(record.Name)
+		var undoneState string
+		switch {
+		case err != nil:
+		case record.State == models.ParticipantPrepared:
+			err = c.withPhaseTimeout(ctx, func(ctx context.Context) error {
+				return participant.Abort(ctx, txn, record.ReservationID)
+			})
+			undoneState = models.ParticipantReleased
+		case record.State == models.ParticipantCommitted:
+			compensator, ok := participant.(Compensator)
+			if !ok {
+				err = fmt.Errorf("%s cannot compensate a booking", record.Name)
+				break
+			}
+			err = c.withPhaseTimeout(ctx, func(ctx context.Context) error {
+				return compensator.Compensate(ctx, txn, record.ReservationID)
+			})
+			undoneState = models.ParticipantCompensated
+		default:
+			continue
+		}
+		if errors.Is(err, ErrCannotUndo) {
+			slog.ErrorContext(ctx, "participant cannot be undone",
+				"participant", record.Name, "error", err)
+			stuck = err
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to undo participant",
+				"participant", record.Name, "error", err)
+			failed = err
+			continue
+		}
+		if err := c.TransactionLog.SetParticipantState(ctx, record, undoneState); err != nil {
+			failed = err
+		}
+	}
+	if failed == nil {
+		failed = c.TransactionLog.SetState(ctx, txn, models.TransactionAborted)
+	}
+	recordPhase(ctx, txn, PhaseAbort, start, errors.Join(failed, stuck))
+	if failed != nil {
+		span.SetStatus(codes.Error, failed.Error())
+		c.setOrderState(ctx, txn.OrderID, models.OrderFailed, failed.Error())
+		recordOutcome(ctx, txn, OutcomeInDoubt)
+		return failed
+	}
+	if stuck != nil {
+		reason = fmt.Sprintf("%s, and a booking could not be undone: %v", reason, stuck)
+		slog.ErrorContext(ctx, "order failed and needs attention", "reason", reason)
+		span.SetStatus(codes.Error, stuck.Error())
+		orderState, outcome = models.OrderFailed, OutcomeFailed
+	}
+	c.setOrderState(ctx, txn.OrderID, orderState, reason)
+	recordOutcome(ctx, txn, outcome)
+	return nil
+}
+
+// commitParticipant books a single prepared participant and logs it.
+func (c *Coordinator) commitParticipant(ctx context.Context, txn *models.GlobalTransaction,
+	record *models.TransactionParticipant) error {
+	participant, err := c.participant(record.Name)
+	if err == nil {
+		err = participant.Commit(ctx, txn, record.ReservationID)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to book participant",
+			"participant", record.Name, "error", err)
+		return err
+	}
+	return c.TransactionLog.SetParticipantState(ctx, record, models.ParticipantCommitted)
+}
+
+// commit books every prepared participant concurrently, within a single
+// phase deadline. It must only be called once the commit decision has been
+// logged, as it never releases a reservation, unless a reservation was lost:
+// the transaction can then never commit, so it is undone and the order
+// failed. The error returned then wraps ErrHoldLost.
+func (c *Coordinator) commit(ctx context.Context, txn *models.GlobalTransaction) error {
+	ctx, span := tracer.Start(ctx, "coordinator: commit")
+	defer span.End()
+	span.SetAttributes(attribute.String("transaction.mode", txn.Mode))
+	start := time.Now()
+	phaseCtx, cancel := context.WithTimeout(ctx, c.phaseTimeout())
+	defer cancel()
+
+	errs := make([]error, len(txn.Participants))
+	var wg sync.WaitGroup
+	for i := range txn.Participants {
+		record := &txn.Participants[i]
+		if record.State != models.ParticipantPrepared {
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.commitParticipant(phaseCtx, txn, record)
+		}(i)
+	}
+	wg.Wait()
+
+	var failed error
+	for _, err := range errs {
+		if errors.Is(err, ErrHoldLost) {
+			recordPhase(ctx, txn, PhaseCommit, start, err)
+			span.SetStatus(codes.Error, err.Error())
+			reason := fmt.Sprintf("a reservation expired before it was booked: %v", err)
+			if undoErr := c.fail(ctx, txn, reason); undoErr != nil {
+				return undoErr
+			}
+			return err
+		}
+		if err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if failed == nil {
+		failed = c.TransactionLog.SetState(ctx, txn, models.TransactionCommitted)
+	}
+	recordPhase(ctx, txn, PhaseCommit, start, failed)
+	if failed != nil {
+		span.SetStatus(codes.Error, failed.Error())
+		c.setOrderState(ctx, txn.OrderID, models.OrderFailed, failed.Error())
+		recordOutcome(ctx, txn, OutcomeInDoubt)
+		return failed
+	}
+	c.setOrderState(ctx, txn.OrderID, models.OrderCommitted, "")
+	recordOutcome(ctx, txn, OutcomeCommitted)
+	return nil
+}
+
+// vote is the answer of a participant to a prepare request.
+type vote struct {
+	participant   Participant
+	reservationID int64
+	err           error
+}
+
+// prepare asks every participant to prepare concurrently, within a single
+// phase deadline, and returns once all of them have voted. Votes are in
+// registration order.
+func (c *Coordinator) prepare(ctx context.Context, txn *models.GlobalTransaction) []vote {
+	ctx, cancel := context.WithTimeout(ctx, c.phaseTimeout())
+	defer cancel()
+
+	votes := make([]vote, len(c.participants))
+	var wg sync.WaitGroup
+	for i, participant := range c.participants {
+		wg.Add(1)
+		go func(i int, participant Participant) {
+			defer wg.Done()
+			reservationID, err := participant.Prepare(ctx, txn)
+			votes[i] = vote{
+				participant:   participant,
+				reservationID: reservationID,
+				err:           err,
+			}
+		}(i, participant)
+	}
+	wg.Wait()
+	return votes
+}
+
+// preparePhase prepares every participant and logs their reservations. It
+// returns the error of a participant that could not prepare, if any.
+func (c *Coordinator) preparePhase(ctx context.Context, txn *models.GlobalTransaction) error {
+	ctx, span := tracer.Start(ctx, "coordinator: prepare")
+	defer span.End()
+	span.SetAttributes(attribute.String("transaction.mode", txn.Mode))
+
+	// every reservation is logged, even when another participant voted no,
+	// so that the abort releases it. One that cannot be logged is released
+	// on the spot.
+	start := time.Now()
+	votes := c.prepare(ctx, txn)
+	var failed error
+	for _, v := range votes {
+		err := v.err
+		if err == nil {
+			err = c.logPrepared(ctx, txn, v.participant, v.reservationID)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to prepare participant",
+				"participant", v.participant.Name(), "error", err)
+			failed = err
+		}
+	}
+	recordPhase(ctx, txn, PhasePrepare, start, failed)
+	if failed != nil {
+		span.SetStatus(codes.Error, failed.Error())
+	}
+	return failed
+}
+
+// Order is what a customer orders.
+type Order struct {
+	Lines []models.OrderLine
+	// DeliveryZone is passed to delivery-svc to pick an agent.
+	DeliveryZone string
+}
+
+// CreateOrder runs a distributed transaction for order in the given mode,
+// or in the coordinator's Mode when mode is empty. Whatever the mode, a
+// failed transaction leaves no participant holding a reservation. The error
+// wraps ErrRefused when a participant voted no and ErrInDoubt, returned
+// along with the order ID, when the outcome is left to recovery.
+func (c *Coordinator) CreateOrder(ctx context.Context, order Order, mode string) (string, error) {
+	if mode == "" {
+		mode = c.Mode
+	}
+	if mode == "" {
+		mode = models.ModeTwoPhaseCommit
+	}
+	quantity := 0
+	for _, line := range order.Lines {
+		quantity += line.Quantity
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("transaction.mode", mode),
+		attribute.Int("order.lines", len(order.Lines)),
+		attribute.Int("order.quantity", quantity),
+		attribute.String("delivery.zone", order.DeliveryZone),
+	)
+
+	switch mode {
+	case models.ModeTwoPhaseCommit:
+		return c.createOrderTwoPhase(ctx, order)
+	case models.ModeSaga:
+		return c.createOrderSaga(ctx, order)
+	}
+	return "", fmt.Errorf("%w %s", ErrUnknownMode, mode)
+}
+
+// createOrderTwoPhase prepares every participant before committing any of
+// them. Both phases fan out to the participants concurrently. If any
+// participant fails to prepare, every participant that already prepared is
+// released.
+func (c *Coordinator) createOrderTwoPhase(ctx context.Context, order Order) (string, error) {
+	txn, err := c.begin(ctx, order, models.ModeTwoPhaseCommit)
+	if err != nil {
+		return "", err
+	}
+	ctx = withOrder(ctx, txn)
+
+	if failed := c.preparePhase(ctx, txn); failed != nil {
+		c.abort(ctx, txn, failed.Error())
+		return "", failed
+	}
+	c.setOrderState(ctx, txn.OrderID, models.OrderPrepared, "")
+
+	// every participant is prepared, log the decision before acting on it
+	if err := c.TransactionLog.SetState(ctx, txn, models.TransactionCommitting); err != nil {
+		slog.ErrorContext(ctx, "failed to log commit decision", "error", err)
+		c.abort(ctx, txn, err.Error())
+		return "", err
+	}
+	if err := c.commit(ctx, txn); err != nil {
+		if errors.Is(err, ErrHoldLost) {
+			return "", err
+		}
+		slog.WarnContext(ctx, "order is committing, it will be completed on recovery")
+		return txn.OrderID, fmt.Errorf("%w: %v", ErrInDoubt, err)
+	}
+
+	slog.InfoContext(ctx, "order created")
+	return txn.OrderID, nil
+}
+
+// Recover drives every unfinished transaction that has made no progress for
+// RecoverAfter to completion, whichever instance started it. Younger ones
+// are left to the instance running them, and each transaction is claimed
+// for RecoverAfter first so that instances recovering at the same time do
+// not drive it twice. Transactions with a logged commit decision are
+// committed, all others, including every unfinished saga, are presumed
+// aborted.
+func (c *Coordinator) Recover(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "coordinator: recover_transactions")
+	defer span.End()
+
+	txns, err := c.TransactionLog.GetUnfinished(ctx, time.Now().Add(-c.recoverAfter()))
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to read transaction log", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int("transactions", len(txns)))
+	for i := range txns {
+		txn := &txns[i]
+		ctx := withOrder(ctx, txn)
+		claimed, err := c.TransactionLog.Claim(ctx, txn, c.recoverAfter())
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to claim order for recovery", "error", err)
+			span.SetStatus(codes.Error, err.Error())
+			continue
+		}
+		if !claimed {
+			slog.InfoContext(ctx, "order is recovered by another instance")
+			continue
+		}
+		if txn.State == models.TransactionCommitting {
+			err = c.commit(ctx, txn)
+		} else {
+			err = c.abort(ctx, txn, "aborted on recovery")
+		}
+		if errors.Is(err, ErrHoldLost) {
+			// the order was failed and undone, there is nothing to retry
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to recover order", "error", err)
+			span.SetStatus(codes.Error, err.Error())
+			continue
+		}
+		slog.InfoContext(ctx, "recovered order")
+	}
+}
+
+// RunRecovery recovers transactions every interval until ctx is done, so
+// that those left by an instance that crashed are finished even though no
+// instance restarts.
+func (c *Coordinator) RunRecovery(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRecoveryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Recover(ctx)
+		}
+	}
+}
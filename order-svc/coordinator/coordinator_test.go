@@ -0,0 +1,516 @@
+package coordinator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *distributedTracer.Recorder
+	recorderErr  error
+)
+
+// testRecorder returns the Recorder shared by the tests of the package,
+// emptied of the spans of earlier tests.
+func testRecorder(t *testing.T) *distributedTracer.Recorder {
+	t.Helper()
+	recorderOnce.Do(func() {
+		recorder, recorderErr = distributedTracer.NewRecorder("order-svc")
+	})
+	if recorderErr != nil {
+		t.Fatalf("NewRecorder failed: %v", recorderErr)
+	}
+	recorder.Reset()
+	return recorder
+}
+
+// memoryLog is a TransactionLog kept in memory. AddParticipant fails for
+// the participant named failAdd. GetUnfinished returns copies, as a
+// database would, which the other methods write back by order ID.
+type memoryLog struct {
+	mu      sync.Mutex
+	txns    []*models.GlobalTransaction
+	failAdd string
+}
+
+// stored returns the logged transaction of txn.
+func (l *memoryLog) stored(txn *models.GlobalTransaction) *models.GlobalTransaction {
+	for _, logged := range l.txns {
+		if logged.OrderID == txn.OrderID {
+			return logged
+		}
+	}
+	return txn
+}
+
+func (l *memoryLog) Begin(ctx context.Context, orderID string, lines []models.OrderLine,
+	deliveryZone string, mode string) (*models.GlobalTransaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	txn := &models.GlobalTransaction{
+		OrderID:      orderID,
+		Lines:        lines,
+		DeliveryZone: deliveryZone,
+		Mode:         mode,
+		State:        models.TransactionStarted,
+	}
+	l.txns = append(l.txns, txn)
+	return txn, nil
+}
+
+func (l *memoryLog) AddParticipant(ctx context.Context, txn *models.GlobalTransaction,
+	name string, reservationID int64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if name == l.failAdd {
+		return errors.New("transaction log is unavailable")
+	}
+	txn.Participants = append(txn.Participants, models.TransactionParticipant{
+		Name:          name,
+		ReservationID: reservationID,
+		State:         models.ParticipantPrepared,
+	})
+	return nil
+}
+
+func (l *memoryLog) SetState(ctx context.Context, txn *models.GlobalTransaction, state string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	txn.State = state
+	l.stored(txn).State = state
+	return nil
+}
+
+func (l *memoryLog) SetParticipantState(ctx context.Context,
+	participant *models.TransactionParticipant, state string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	participant.State = state
+	return nil
+}
+
+func (l *memoryLog) GetUnfinished(ctx context.Context, idleSince time.Time) ([]models.GlobalTransaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var unfinished []models.GlobalTransaction
+	for _, txn := range l.txns {
+		switch txn.State {
+		case models.TransactionCommitted, models.TransactionAborted:
+		default:
+			copied := *txn
+			copied.Participants = append([]models.TransactionParticipant(nil), txn.Participants...)
+			unfinished = append(unfinished, copied)
+		}
+	}
+	return unfinished, nil
+}
+
+func (l *memoryLog) Claim(ctx context.Context, txn *models.GlobalTransaction,
+	lease time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	logged := l.stored(txn)
+	now := time.Now()
+	if logged.State != txn.State ||
+		(logged.RecoveryLockedUntil.Valid && !logged.RecoveryLockedUntil.Time.Before(now)) {
+		return false, nil
+	}
+	logged.RecoveryLockedUntil = sql.NullTime{Time: now.Add(lease), Valid: true}
+	return true, nil
+}
+
+// memoryOrders is an OrderStore kept in memory.
+type memoryOrders struct {
+	mu      sync.Mutex
+	states  map[string]string
+	reasons map[string]string
+}
+
+func (o *memoryOrders) Create(ctx context.Context, orderID string, lines []models.OrderLine,
+	deliveryZone string, mode string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.states[orderID] = models.OrderPending
+	return nil
+}
+
+func (o *memoryOrders) SetState(ctx context.Context, orderID string, state string, reason string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.states[orderID] = state
+	o.reasons[orderID] = reason
+	return nil
+}
+
+// fakeParticipant answers Prepare with reservationID, or prepareErr, Commit
+// with commitErr and Compensate with compensateErr. It traces every call the
+// way HTTPParticipant does.
+type fakeParticipant struct {
+	name          string
+	reservationID int64
+	prepareErr    error
+	commitErr     error
+	compensateErr error
+
+	mu          sync.Mutex
+	committed   []int64
+	aborted     []int64
+	compensated []int64
+}
+
+func (p *fakeParticipant) trace(ctx context.Context, operation string, err error) {
+	_, span := otel.Tracer("coordinator-test").Start(ctx, "coordinator: "+operation+" in "+p.name)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (p *fakeParticipant) Name() string {
+	return p.name
+}
+
+func (p *fakeParticipant) Prepare(ctx context.Context, txn *models.GlobalTransaction) (int64, error) {
+	p.trace(ctx, "prepare", p.prepareErr)
+	if p.prepareErr != nil {
+		return 0, p.prepareErr
+	}
+	return p.reservationID, nil
+}
+
+func (p *fakeParticipant) Commit(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error {
+	p.trace(ctx, "commit", p.commitErr)
+	if p.commitErr != nil {
+		return p.commitErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.committed = append(p.committed, reservationID)
+	return nil
+}
+
+func (p *fakeParticipant) Abort(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error {
+	p.trace(ctx, "abort", nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.aborted = append(p.aborted, reservationID)
+	return nil
+}
+
+func (p *fakeParticipant) Compensate(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error {
+	p.trace(ctx, "compensate", p.compensateErr)
+	if p.compensateErr != nil {
+		return p.compensateErr
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.compensated = append(p.compensated, reservationID)
+	return nil
+}
+
+// placeOrder runs a two phase commit for one line under a "POST /order"
+// span, as the order handler does.
+func placeOrder(c *Coordinator) (string, error) {
+	ctx, span := otel.Tracer("coordinator-test").Start(context.Background(), "POST /order")
+	defer span.End()
+	return c.CreateOrder(ctx, Order{
+		Lines:        []models.OrderLine{{ItemID: 1, Quantity: 2}},
+		DeliveryZone: "berlin/mitte",
+	}, models.ModeTwoPhaseCommit)
+}
+
+func newTestCoordinator(log *memoryLog, participants ...Participant) (*Coordinator, *memoryOrders) {
+	orders := &memoryOrders{states: make(map[string]string), reasons: make(map[string]string)}
+	c := &Coordinator{
+		TransactionLog: log,
+		Orders:         orders,
+		PhaseTimeout:   time.Second,
+	}
+	for _, participant := range participants {
+		c.Register(participant)
+	}
+	return c, orders
+}
+
+func TestCreateOrderAbortsWhenDeliveryCannotBeReserved(t *testing.T) {
+	r := testRecorder(t)
+	store := &fakeParticipant{name: "store-svc", reservationID: 7}
+	delivery := &fakeParticipant{name: "delivery-svc",
+		prepareErr: errors.New("no delivery agent is available")}
+	log := &memoryLog{}
+	c, orders := newTestCoordinator(log, store, delivery)
+
+	if _, err := placeOrder(c); err == nil {
+		t.Fatal("CreateOrder succeeded, want the delivery error")
+	}
+
+	r.AssertSpan(t, "coordinator: prepare").
+		HasError().
+		HasParent("POST /order").
+		HasChild("coordinator: prepare in store-svc").
+		HasChild("coordinator: prepare in delivery-svc").
+		HasAttribute("transaction.mode", models.ModeTwoPhaseCommit)
+	r.AssertSpan(t, "coordinator: prepare in delivery-svc").HasError()
+	r.AssertSpan(t, "coordinator: abort").
+		HasNoError().
+		HasParent("POST /order").
+		HasChild("coordinator: abort in store-svc")
+	r.AssertSpan(t, "POST /order").
+		HasAttribute("order.lines", 1).
+		HasAttribute("order.quantity", 2).
+		HasAttribute("delivery.zone", "berlin/mitte")
+	if _, ok := r.Find("coordinator: commit"); ok {
+		t.Errorf("an aborted order should not commit, got:\n%s", r.Tree())
+	}
+
+	if len(store.aborted) != 1 || store.aborted[0] != 7 {
+		t.Errorf("store-svc released %v, want [7]", store.aborted)
+	}
+	if len(delivery.aborted) != 0 {
+		t.Errorf("delivery-svc released %v, it held nothing", delivery.aborted)
+	}
+	txn := log.txns[0]
+	if txn.State != models.TransactionAborted {
+		t.Errorf("transaction is %s, want %s", txn.State, models.TransactionAborted)
+	}
+	if state := orders.states[txn.OrderID]; state != models.OrderAborted {
+		t.Errorf("order is %s, want %s", state, models.OrderAborted)
+	}
+}
+
+func TestCreateOrderCommitsEveryParticipant(t *testing.T) {
+	r := testRecorder(t)
+	store := &fakeParticipant{name: "store-svc", reservationID: 7}
+	delivery := &fakeParticipant{name: "delivery-svc", reservationID: 3}
+	log := &memoryLog{}
+	c, orders := newTestCoordinator(log, store, delivery)
+
+	orderID, err := placeOrder(c)
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+
+	r.AssertSpan(t, "coordinator: prepare").HasNoError().HasParent("POST /order")
+	r.AssertSpan(t, "coordinator: commit").
+		HasNoError().
+		HasParent("POST /order").
+		HasChild("coordinator: commit in store-svc").
+		HasChild("coordinator: commit in delivery-svc")
+	if _, ok := r.Find("coordinator: abort"); ok {
+		t.Errorf("a committed order should not abort, got:\n%s", r.Tree())
+	}
+
+	if len(store.committed) != 1 || store.committed[0] != 7 {
+		t.Errorf("store-svc booked %v, want [7]", store.committed)
+	}
+	if len(delivery.committed) != 1 || delivery.committed[0] != 3 {
+		t.Errorf("delivery-svc booked %v, want [3]", delivery.committed)
+	}
+	if state := orders.states[orderID]; state != models.OrderCommitted {
+		t.Errorf("order is %s, want %s", state, models.OrderCommitted)
+	}
+}
+
+func TestCreateOrderReleasesReservationsThatCannotBeLogged(t *testing.T) {
+	testRecorder(t)
+	store := &fakeParticipant{name: "store-svc", reservationID: 7}
+	delivery := &fakeParticipant{name: "delivery-svc", reservationID: 3}
+	log := &memoryLog{failAdd: "delivery-svc"}
+	c, _ := newTestCoordinator(log, store, delivery)
+
+	if _, err := placeOrder(c); err == nil {
+		t.Fatal("CreateOrder succeeded, want the transaction log error")
+	}
+
+	// the abort releases what was logged, the rest is released on the spot
+	if len(store.aborted) != 1 || store.aborted[0] != 7 {
+		t.Errorf("store-svc released %v, want [7]", store.aborted)
+	}
+	if len(delivery.aborted) != 1 || delivery.aborted[0] != 3 {
+		t.Errorf("delivery-svc released %v, want [3]", delivery.aborted)
+	}
+	if len(store.committed)+len(delivery.committed) != 0 {
+		t.Error("nothing should be booked")
+	}
+}
+
+func TestCreateOrderTellsRefusalsFromUndecidedOrders(t *testing.T) {
+	testRecorder(t)
+	tests := []struct {
+		name        string
+		delivery    *fakeParticipant
+		log         *memoryLog
+		refused     bool
+		inDoubt     bool
+		transaction string
+	}{{
+		name: "vote no",
+		delivery: &fakeParticipant{name: "delivery-svc",
+			prepareErr: fmt.Errorf("delivery-svc returned status 409: %w", ErrRefused)},
+		log:         &memoryLog{},
+		refused:     true,
+		transaction: models.TransactionAborted,
+	}, {
+		name:        "transaction log error",
+		delivery:    &fakeParticipant{name: "delivery-svc", reservationID: 3},
+		log:         &memoryLog{failAdd: "delivery-svc"},
+		transaction: models.TransactionAborted,
+	}, {
+		name: "commit not acknowledged",
+		delivery: &fakeParticipant{name: "delivery-svc", reservationID: 3,
+			commitErr: errors.New("delivery-svc returned status 503")},
+		log:         &memoryLog{},
+		inDoubt:     true,
+		transaction: models.TransactionCommitting,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &fakeParticipant{name: "store-svc", reservationID: 7}
+			c, _ := newTestCoordinator(test.log, store, test.delivery)
+
+			orderID, err := placeOrder(c)
+			if err == nil {
+				t.Fatal("CreateOrder succeeded, want an error")
+			}
+			if errors.Is(err, ErrRefused) != test.refused || errors.Is(err, ErrInDoubt) != test.inDoubt {
+				t.Errorf("CreateOrder failed with %v, want refused %t and in doubt %t",
+					err, test.refused, test.inDoubt)
+			}
+			txn := test.log.txns[0]
+			if test.inDoubt != (orderID == txn.OrderID) {
+				t.Errorf("CreateOrder returned order ID %q for order %s", orderID, txn.OrderID)
+			}
+			if txn.State != test.transaction {
+				t.Errorf("transaction is %s, want %s", txn.State, test.transaction)
+			}
+		})
+	}
+}
+
+func TestRecoverSkipsTransactionsClaimedByAnotherInstance(t *testing.T) {
+	testRecorder(t)
+	store := &fakeParticipant{name: "store-svc"}
+	log := &memoryLog{txns: []*models.GlobalTransaction{{
+		OrderID: "order-1",
+		Mode:    models.ModeTwoPhaseCommit,
+		State:   models.TransactionAborting,
+		Participants: []models.TransactionParticipant{
+			{Name: "store-svc", ReservationID: 7, State: models.ParticipantPrepared},
+		},
+	}}}
+	c, _ := newTestCoordinator(log, store)
+
+	// another instance is recovering the transaction
+	log.txns[0].RecoveryLockedUntil = sql.NullTime{Time: time.Now().Add(time.Minute), Valid: true}
+	c.Recover(context.Background())
+	if len(store.aborted) != 0 {
+		t.Fatalf("store-svc released %v while another instance held the transaction", store.aborted)
+	}
+
+	// its lease ran out without the transaction being finished
+	log.txns[0].RecoveryLockedUntil.Time = time.Now().Add(-time.Second)
+	c.Recover(context.Background())
+	if len(store.aborted) != 1 || store.aborted[0] != 7 {
+		t.Errorf("store-svc released %v, want [7]", store.aborted)
+	}
+	if log.txns[0].State != models.TransactionAborted {
+		t.Errorf("transaction is %s, want %s", log.txns[0].State, models.TransactionAborted)
+	}
+}
+
+func TestRecoverFailsOrdersWhoseReservationExpired(t *testing.T) {
+	testRecorder(t)
+	store := &fakeParticipant{name: "store-svc",
+		commitErr: fmt.Errorf("store-svc returned status 410: %w", ErrHoldLost)}
+	delivery := &fakeParticipant{name: "delivery-svc"}
+	log := &memoryLog{txns: []*models.GlobalTransaction{{
+		OrderID: "order-1",
+		Mode:    models.ModeTwoPhaseCommit,
+		State:   models.TransactionCommitting,
+		Participants: []models.TransactionParticipant{
+			{Name: "store-svc", ReservationID: 7, State: models.ParticipantPrepared},
+			{Name: "delivery-svc", ReservationID: 3, State: models.ParticipantPrepared},
+		},
+	}}}
+	c, orders := newTestCoordinator(log, store, delivery)
+
+	c.Recover(context.Background())
+
+	// the agent booked before the item was found lost is given back, and
+	// the order is left failed rather than committing forever
+	if len(delivery.compensated) != 1 || delivery.compensated[0] != 3 {
+		t.Errorf("delivery-svc compensated %v, want [3]", delivery.compensated)
+	}
+	if len(store.aborted) != 1 || store.aborted[0] != 7 {
+		t.Errorf("store-svc released %v, want [7]", store.aborted)
+	}
+	if log.txns[0].State != models.TransactionAborted {
+		t.Errorf("transaction is %s, want %s", log.txns[0].State, models.TransactionAborted)
+	}
+	if orders.states["order-1"] != models.OrderFailed ||
+		!strings.Contains(orders.reasons["order-1"], "expired") {
+		t.Errorf("order is %s (%q), want %s with the expiry as reason",
+			orders.states["order-1"], orders.reasons["order-1"], models.OrderFailed)
+	}
+
+	log.txns[0].RecoveryLockedUntil = sql.NullTime{}
+	c.Recover(context.Background())
+	if len(delivery.committed) != 1 || len(store.aborted) != 1 {
+		t.Error("a failed order should not be recovered again")
+	}
+}
+
+func TestRecoverFailsOrdersWhoseBookingCannotBeUndone(t *testing.T) {
+	testRecorder(t)
+	store := &fakeParticipant{name: "store-svc"}
+	delivery := &fakeParticipant{name: "delivery-svc",
+		compensateErr: fmt.Errorf("delivery-svc returned status 409: %w", ErrCannotUndo)}
+	log := &memoryLog{txns: []*models.GlobalTransaction{{
+		OrderID: "order-1",
+		Mode:    models.ModeSaga,
+		State:   models.TransactionStarted,
+		Participants: []models.TransactionParticipant{
+			{Name: "store-svc", ReservationID: 7, State: models.ParticipantCommitted},
+			{Name: "delivery-svc", ReservationID: 3, State: models.ParticipantCommitted},
+		},
+	}}}
+	c, orders := newTestCoordinator(log, store, delivery)
+
+	c.Recover(context.Background())
+
+	// the item is given back, the delivery that is underway is left as it
+	// is and the order failed rather than compensating forever
+	if len(store.compensated) != 1 || store.compensated[0] != 7 {
+		t.Errorf("store-svc compensated %v, want [7]", store.compensated)
+	}
+	txn := log.txns[0]
+	if txn.State != models.TransactionAborted {
+		t.Errorf("transaction is %s, want %s", txn.State, models.TransactionAborted)
+	}
+	if txn.Participants[1].State != models.ParticipantCommitted {
+		t.Errorf("delivery-svc is %s, want it left %s", txn.Participants[1].State, models.ParticipantCommitted)
+	}
+	if orders.states["order-1"] != models.OrderFailed ||
+		!strings.Contains(orders.reasons["order-1"], "could not be undone") {
+		t.Errorf("order is %s (%q), want %s with the booking left as reason",
+			orders.states["order-1"], orders.reasons["order-1"], models.OrderFailed)
+	}
+
+	txn.RecoveryLockedUntil = sql.NullTime{}
+	c.Recover(context.Background())
+	if len(store.compensated) != 1 {
+		t.Error("a failed order should not be recovered again")
+	}
+}
@@ -0,0 +1,263 @@
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/dto"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"go.opentelemetry.io/otel/codes"
+)
+
+// HTTPParticipant is a Participant reached over HTTP. Prepare is a POST,
+// with the body built by PrepareBody if any, that answers with a
+// dto.ReservationDto, Commit is a POST of a dto.BookingDto and
+// Abort is a POST of a dto.ReleaseDto. Compensate is a POST of the same
+// dto.BookingDto that was committed. Prepare and Commit carry the order ID as
+// their Idempotency-Key, so a commit retried on recovery is not applied
+// twice. Participants hold a reservation for the order named by the key of
+// Prepare and only book or release it for that order. Paths are built per
+// transaction so they can carry transaction data such as the item ID.
+type HTTPParticipant struct {
+	ParticipantName string
+	BaseURL         string
+	// Client sends the requests, it should use a tracer.Transport so that
+	// every call is traced and carries the trace context.
+	Client *http.Client
+	// CheckPath is optional, when set and not empty for the transaction it
+	// is requested with a GET before preparing and any non 200 answer fails
+	// the prepare.
+	CheckPath   func(txn *models.GlobalTransaction) string
+	PreparePath func(txn *models.GlobalTransaction) string
+	// PrepareBody is optional, it builds the body sent to PreparePath.
+	PrepareBody func(txn *models.GlobalTransaction) any
+	CommitPath  func(txn *models.GlobalTransaction) string
+	AbortPath   func(txn *models.GlobalTransaction) string
+	// CompensatePath is optional, a participant without one cannot be used
+	// in a saga.
+	CompensatePath func(txn *models.GlobalTransaction) string
+}
+
+func staticPath(path string) func(txn *models.GlobalTransaction) string {
+	return func(txn *models.GlobalTransaction) string {
+		return path
+	}
+}
+
+// storePath sends transactions with order lines to the batch endpoints of
+// store-svc, which hold every line under one reservation ID. Transactions
+// logged before orders had lines keep using the endpoints of their item.
+func storePath(suffix string) func(txn *models.GlobalTransaction) string {
+	return func(txn *models.GlobalTransaction) string {
+		if len(txn.Lines) > 0 {
+			if suffix == "" {
+				return ""
+			}
+			return "/store/batch" + suffix
+		}
+		return "/store/item/" + strconv.Itoa(txn.ItemID) + suffix
+	}
+}
+
+func storeBatch(txn *models.GlobalTransaction) any {
+	if len(txn.Lines) == 0 {
+		return nil
+	}
+	batch := dto.BatchReservationDto{Items: make([]dto.BatchItemDto, 0, len(txn.Lines))}
+	for _, line := range txn.Lines {
+		batch.Items = append(batch.Items, dto.BatchItemDto{
+			ItemID:   line.ItemID,
+			Quantity: line.Quantity,
+		})
+	}
+	return batch
+}
+
+func NewStoreParticipant(baseURL string, client *http.Client) *HTTPParticipant {
+	return &HTTPParticipant{
+		ParticipantName: "store-svc",
+		BaseURL:         baseURL,
+		Client:          client,
+		CheckPath:       storePath(""),
+		PreparePath:     storePath("/reserve"),
+		PrepareBody:     storeBatch,
+		CommitPath:      storePath("/book"),
+		AbortPath:       storePath("/release"),
+		CompensatePath:  storePath("/unbook"),
+	}
+}
+
+func deliveryZone(txn *models.GlobalTransaction) any {
+	return dto.AgentReservationDto{Zone: txn.DeliveryZone}
+}
+
+func NewDeliveryParticipant(baseURL string, client *http.Client) *HTTPParticipant {
+	return &HTTPParticipant{
+		ParticipantName: "delivery-svc",
+		BaseURL:         baseURL,
+		Client:          client,
+		PreparePath:     staticPath("/agent/reserve"),
+		PrepareBody:     deliveryZone,
+		CommitPath:      staticPath("/agent/book"),
+		AbortPath:       staticPath("/agent/release"),
+		CompensatePath:  staticPath("/agent/unbook"),
+	}
+}
+
+func (p *HTTPParticipant) Name() string {
+	return p.ParticipantName
+}
+
+func (p *HTTPParticipant) Prepare(ctx context.Context, txn *models.GlobalTransaction) (int64, error) {
+	if p.CheckPath != nil {
+		if path := p.CheckPath(txn); path != "" {
+			err := p.call(ctx, "coordinator: check in "+p.ParticipantName,
+				"GET", p.BaseURL+path, "", nil, nil)
+			if err != nil {
+				return 0, refused(err)
+			}
+		}
+	}
+	var body any
+	if p.PrepareBody != nil {
+		body = p.PrepareBody(txn)
+	}
+	var reservation dto.ReservationDto
+	err := p.call(ctx, "coordinator: prepare in "+p.ParticipantName,
+		"POST", p.BaseURL+p.PreparePath(txn), txn.OrderID, body, &reservation)
+	if err != nil {
+		return 0, refused(err)
+	}
+	return reservation.ReservationID, nil
+}
+
+func (p *HTTPParticipant) Commit(ctx context.Context, txn *models.GlobalTransaction,
+	reservationID int64) error {
+	err := p.call(ctx, "coordinator: commit in "+p.ParticipantName,
+		"POST", p.BaseURL+p.CommitPath(txn), txn.OrderID,
+		dto.BookingDto{
+			OrderID:       txn.OrderID,
+			ReservationID: reservationID,
+		}, nil)
+	// participants answer 410 for a reservation that expired
+	var statusErr *participantStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGone {
+		return fmt.Errorf("%s: %w", statusErr.Error(), ErrHoldLost)
+	}
+	return err
+}
+
+func (p *HTTPParticipant) Abort(ctx context.Context, txn *models.GlobalTransaction,
+	reservationID int64) error {
+	return ignoreNotFound(p.call(ctx, "coordinator: abort in "+p.ParticipantName,
+		"POST", p.BaseURL+p.AbortPath(txn), "",
+		dto.ReleaseDto{
+			OrderID:       txn.OrderID,
+			ReservationID: reservationID,
+		}, nil))
+}
+
+func (p *HTTPParticipant) Compensate(ctx context.Context, txn *models.GlobalTransaction,
+	reservationID int64) error {
+	if p.CompensatePath == nil {
+		return fmt.Errorf("%s cannot compensate a booking", p.ParticipantName)
+	}
+	err := ignoreNotFound(p.call(ctx, "coordinator: compensate in "+p.ParticipantName,
+		"POST", p.BaseURL+p.CompensatePath(txn), "",
+		dto.BookingDto{
+			OrderID:       txn.OrderID,
+			ReservationID: reservationID,
+		}, nil))
+	// participants answer 409 for a booking that has gone too far to cancel
+	var statusErr *participantStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%s: %w", statusErr.Error(), ErrCannotUndo)
+	}
+	return err
+}
+
+// refused marks a 4xx answer to a check or reserve call as a vote no.
+// Participants answer 5xx when they could not tell.
+func refused(err error) error {
+	var statusErr *participantStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode >= 400 && statusErr.StatusCode < 500 {
+		return fmt.Errorf("%s: %w", statusErr.Error(), ErrRefused)
+	}
+	return err
+}
+
+// ignoreNotFound treats a 404 from a participant as success. Participants
+// only answer 404 for a reservation that is no longer held, which has
+// already been undone, most likely by an earlier attempt that crashed
+// before it was logged. Any other failure is a 5xx and is retried.
+func ignoreNotFound(err error) error {
+	var statusErr *participantStatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// participantStatusError is returned by call when a participant
+// answers with anything other than 200.
+type participantStatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *participantStatusError) Error() string {
+	return fmt.Sprintf("%s returned status %d", e.URL, e.StatusCode)
+}
+
+// call sends a request to the participant and decodes the JSON response
+// into out when out is not nil. Any transport error or non 200 response is
+// reported as an error. A non empty idempotencyKey is sent so that a retried
+// call is not applied twice.
+func (p *HTTPParticipant) call(ctx context.Context, operationName string,
+	method string, url string, idempotencyKey string, body any, out any) error {
+	ctx, span := tracer.Start(ctx, operationName)
+	defer span.End()
+
+	var toSend io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("failed to encode request for %s: %w", url, err)
+		}
+		toSend = bytes.NewBuffer(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, toSend)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to build request for %s: %w", url, err)
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(idempotency.HeaderKey, idempotencyKey)
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to call %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		statusErr := &participantStatusError{URL: url, StatusCode: resp.StatusCode}
+		span.SetStatus(codes.Error, statusErr.Error())
+		return statusErr
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return fmt.Errorf("failed to decode response from %s: %w", url, err)
+		}
+	}
+	return nil
+}
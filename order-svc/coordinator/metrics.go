@@ -0,0 +1,71 @@
+package coordinator
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Outcomes of a transaction. A transaction is in doubt when its commit or
+// abort failed part way, it is finished on recovery. It failed when it
+// could not be finished either way and was undone, which needs an operator
+// to look at the order.
+const (
+	OutcomeCommitted = "committed"
+	OutcomeAborted   = "aborted"
+	OutcomeInDoubt   = "in_doubt"
+	OutcomeFailed    = "failed"
+)
+
+// Phases of a transaction.
+const (
+	PhasePrepare = "prepare"
+	PhaseCommit  = "commit"
+	PhaseAbort   = "abort"
+)
+
+var meter = otel.Meter("github.com/Roy19/distributed-transaction-2pc/order-svc/coordinator")
+
+var (
+	transactionOutcomes metric.Int64Counter
+	phaseDuration       metric.Float64Histogram
+)
+
+func init() {
+	var err error
+	transactionOutcomes, err = meter.Int64Counter("coordinator.transactions",
+		metric.WithDescription("Number of finished transactions by outcome."),
+		metric.WithUnit("{transaction}"))
+	if err != nil {
+		slog.Error("failed to create the transaction outcome counter", "error", err)
+	}
+	phaseDuration, err = meter.Float64Histogram("coordinator.phase.duration",
+		metric.WithDescription("Duration of the phases of a transaction."),
+		metric.WithUnit("s"))
+	if err != nil {
+		slog.Error("failed to create the phase duration histogram", "error", err)
+	}
+}
+
+func recordOutcome(ctx context.Context, txn *models.GlobalTransaction, outcome string) {
+	transactionOutcomes.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("transaction.mode", txn.Mode),
+		attribute.String("transaction.outcome", outcome),
+	))
+}
+
+// recordPhase records how long a phase that started at start took and
+// whether it failed.
+func recordPhase(ctx context.Context, txn *models.GlobalTransaction, phase string,
+	start time.Time, err error) {
+	phaseDuration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(
+		attribute.String("transaction.mode", txn.Mode),
+		attribute.String("transaction.phase", phase),
+		attribute.Bool("transaction.phase.failed", err != nil),
+	))
+}
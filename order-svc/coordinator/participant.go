@@ -0,0 +1,38 @@
+package coordinator
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+)
+
+// ErrRefused is returned by Prepare when the participant votes no, for
+// instance because it is out of stock. Retrying the order will not help.
+var ErrRefused = errors.New("participant refused the reservation")
+
+// ErrHoldLost is returned by Commit when the reservation is no longer held,
+// most likely because it expired before the commit could book it. It can
+// never be booked, so the transaction cannot commit.
+var ErrHoldLost = errors.New("reservation is no longer held")
+
+// ErrCannotUndo is returned by Compensate when the booking can no longer be
+// undone, for instance because the delivery is already underway. Retrying
+// will not help.
+var ErrCannotUndo = errors.New("booking can no longer be undone")
+
+// Participant is a service taking part in a distributed transaction. Prepare
+// reserves whatever the participant needs for the transaction and returns
+// the reservation ID, which is later passed to either Commit or Abort.
+type Participant interface {
+	Name() string
+	Prepare(ctx context.Context, txn *models.GlobalTransaction) (int64, error)
+	Commit(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error
+	Abort(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error
+}
+
+// Compensator is implemented by participants that can undo a committed
+// reservation. Only such participants can take part in a saga.
+type Compensator interface {
+	Compensate(ctx context.Context, txn *models.GlobalTransaction, reservationID int64) error
+}
@@ -0,0 +1,74 @@
+package coordinator
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+)
+
+// createOrderSaga prepares and immediately commits each participant in turn,
+// so no participant holds a reservation while waiting on the others. If a
+// step fails, the steps already taken are undone in reverse order by
+// releasing or compensating them.
+func (c *Coordinator) createOrderSaga(ctx context.Context, order Order) (string, error) {
+	for _, participant := range c.participants {
+		if _, ok := participant.(Compensator); !ok {
+			return "", fmt.Errorf("%s cannot take part in a saga", participant.Name())
+		}
+	}
+
+	txn, err := c.begin(ctx, order, models.ModeSaga)
+	if err != nil {
+		return "", err
+	}
+	ctx = withOrder(ctx, txn)
+
+	for _, participant := range c.participants {
+		start := time.Now()
+		var reservationID int64
+		err := c.withPhaseTimeout(ctx, func(ctx context.Context) (err error) {
+			reservationID, err = participant.Prepare(ctx, txn)
+			return err
+		})
+		if err == nil {
+			err = c.logPrepared(ctx, txn, participant, reservationID)
+		}
+		recordPhase(ctx, txn, PhasePrepare, start, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to prepare participant",
+				"participant", participant.Name(), "error", err)
+			c.abort(ctx, txn, err.Error())
+			return "", err
+		}
+
+		record := &txn.Participants[len(txn.Participants)-1]
+		start = time.Now()
+		err = c.withPhaseTimeout(ctx, func(ctx context.Context) error {
+			return participant.Commit(ctx, txn, reservationID)
+		})
+		if err == nil {
+			err = c.TransactionLog.SetParticipantState(ctx, record, models.ParticipantCommitted)
+		}
+		recordPhase(ctx, txn, PhaseCommit, start, err)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to book participant",
+				"participant", participant.Name(), "error", err)
+			c.abort(ctx, txn, err.Error())
+			return "", err
+		}
+	}
+
+	if err := c.TransactionLog.SetState(ctx, txn, models.TransactionCommitted); err != nil {
+		c.setOrderState(ctx, txn.OrderID, models.OrderFailed, err.Error())
+		recordOutcome(ctx, txn, OutcomeInDoubt)
+		return txn.OrderID, fmt.Errorf("%w: %v", ErrInDoubt, err)
+	}
+	c.setOrderState(ctx, txn.OrderID, models.OrderCommitted, "")
+	recordOutcome(ctx, txn, OutcomeCommitted)
+
+	slog.InfoContext(ctx, "order created")
+	return txn.OrderID, nil
+}
@@ -0,0 +1,34 @@
+package coordinator
+
+import (
+	"context"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+)
+
+// TransactionLog durably records every transaction and the reservation
+// each participant holds for it, so that an unfinished transaction can be
+// recovered. It is implemented by repository.TransactionLogRepository.
+type TransactionLog interface {
+	Begin(ctx context.Context, orderID string, lines []models.OrderLine,
+		deliveryZone string, mode string) (*models.GlobalTransaction, error)
+	AddParticipant(ctx context.Context, txn *models.GlobalTransaction, name string, reservationID int64) error
+	SetState(ctx context.Context, txn *models.GlobalTransaction, state string) error
+	SetParticipantState(ctx context.Context, participant *models.TransactionParticipant, state string) error
+	// GetUnfinished returns the unfinished transactions that have not been
+	// logged to since idleSince.
+	GetUnfinished(ctx context.Context, idleSince time.Time) ([]models.GlobalTransaction, error)
+	// Claim leases txn to the caller for lease, unless another instance
+	// holds it or txn has left the state it was read in. It reports
+	// whether the caller got the lease.
+	Claim(ctx context.Context, txn *models.GlobalTransaction, lease time.Duration) (bool, error)
+}
+
+// OrderStore keeps the customer facing orders. It is implemented by
+// repository.OrderRepository.
+type OrderStore interface {
+	Create(ctx context.Context, orderID string, lines []models.OrderLine,
+		deliveryZone string, mode string) error
+	SetState(ctx context.Context, orderID string, state string, reason string) error
+}
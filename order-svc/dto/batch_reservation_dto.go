@@ -0,0 +1,12 @@
+package dto
+
+// BatchReservationDto asks store-svc to reserve every item of an order at
+// once.
+type BatchReservationDto struct {
+	Items []BatchItemDto `json:"items"`
+}
+
+type BatchItemDto struct {
+	ItemID   int `json:"itemId"`
+	Quantity int `json:"quantity"`
+}
@@ -1,6 +1,6 @@
 package dto
 
-type ItemBookingDto struct {
+type BookingDto struct {
 	ReservationID int64  `json:"reservationId"`
 	OrderID       string `json:"orderId"`
 }
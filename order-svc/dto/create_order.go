@@ -1,5 +1,21 @@
 package dto
 
 type CreateOrderRequest struct {
-	ItemID int `json:"item_id"`
+	// Items are the lines of the order
+	Items []OrderLineDto `json:"items,omitempty"`
+	// ItemID orders a single unit of an item, it is kept for clients that
+	// predate Items and cannot be combined with them
+	ItemID int `json:"item_id,omitempty"`
+	// DeliveryZone is where the order is delivered, such as "berlin/mitte",
+	// delivery-svc picks an agent for it
+	DeliveryZone string `json:"delivery_zone,omitempty"`
+	// Mode is either "2pc" or "saga", the service default is used when empty
+	Mode string `json:"mode,omitempty"`
+	// CustomerTier is passed to every participant as baggage
+	CustomerTier string `json:"customer_tier,omitempty"`
+}
+
+type OrderLineDto struct {
+	ItemID   int `json:"item_id"`
+	Quantity int `json:"quantity"`
 }
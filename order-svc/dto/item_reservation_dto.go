@@ -1,6 +0,0 @@
-package dto
-
-type ItemReservationDto struct {
-	ReservationID int64  `json:"id"`
-	Message       string `json:"message"`
-}
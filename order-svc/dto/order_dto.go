@@ -0,0 +1,16 @@
+package dto
+
+import "time"
+
+type OrderDto struct {
+	OrderID      string           `json:"order_id"`
+	ItemID       int              `json:"item_id,omitempty"`
+	Items        []OrderLineDto   `json:"items"`
+	DeliveryZone string           `json:"delivery_zone,omitempty"`
+	Mode         string           `json:"mode"`
+	State        string           `json:"state"`
+	Reason       string           `json:"reason,omitempty"`
+	Reservations map[string]int64 `json:"reservations"`
+	CreatedAt    time.Time        `json:"created_at"`
+	UpdatedAt    time.Time        `json:"updated_at"`
+}
@@ -1,6 +1,6 @@
 package dto
 
-type DeliveryAgentBookingDto struct {
+type ReleaseDto struct {
 	ReservationID int64  `json:"reservationId"`
 	OrderID       string `json:"orderId"`
 }
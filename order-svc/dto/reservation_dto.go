@@ -0,0 +1,11 @@
+package dto
+
+// AgentReservationDto asks delivery-svc for an agent delivering to Zone.
+type AgentReservationDto struct {
+	Zone string `json:"zone,omitempty"`
+}
+
+type ReservationDto struct {
+	ReservationID int64  `json:"id"`
+	Message       string `json:"message"`
+}
@@ -1,182 +1,115 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
 
+	"github.com/Roy19/distributed-transaction-2pc/config"
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/logging"
+	"github.com/Roy19/distributed-transaction-2pc/metrics"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/controllers"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/coordinator"
 	"github.com/Roy19/distributed-transaction-2pc/order-svc/dto"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/migrations"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/repository"
 	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
 	"github.com/Roy19/distributed-transaction-2pc/utils"
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	tracer opentracing.Tracer
+	orderCoordinator *coordinator.Coordinator
 )
 
-func createOrder() string {
-	return uuid.New().String()
-}
-
-func coordinator(ctx context.Context, itemID int) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "coordinator: get_item_availability from store_svc")
-	req, _ := http.NewRequestWithContext(ctx, "GET",
-		"http://localhost:8080/store/item/"+strconv.Itoa(itemID), nil)
-	span.Tracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Error fetching item from store-svc: ", err)
-		span.SetTag("error", true)
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Error fetching item from store-svc: ", resp.StatusCode)
-		span.SetTag("error", true)
-	}
-	span.Finish()
-
-	// reserve item
-	span, _ = opentracing.StartSpanFromContext(ctx, "coordinator: reserve_item in store_svc")
-	req, _ = http.NewRequestWithContext(ctx,
-		"POST",
-		"http://localhost:8080/store/item/"+strconv.Itoa(itemID)+"/reserve",
-		nil,
-	)
-	span.Tracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-	resp, err = http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Error reserving item from store-svc: ", err)
-		span.SetTag("error", true)
+const (
+	defaultOrdersLimit = 20
+	maxOrdersLimit     = 100
+	// maxLineQuantity bounds the units of an item in one order.
+	maxLineQuantity = 100
+	// participantRetries is how often a participant call that is safe to
+	// repeat is retried after a transport error or an unavailable answer.
+	participantRetries = 2
+)
 
+// queryInt reads a non negative integer query parameter, returning def when
+// it is absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
 	}
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Error reserving item from store-svc: ", resp.StatusCode)
-		span.SetTag("error", true)
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non negative integer", name)
 	}
-	var itemReservationDto dto.ItemReservationDto
-	err = json.NewDecoder(resp.Body).Decode(&itemReservationDto)
-	if err != nil {
-		log.Println("Error decoding item reservation response: ", err)
-		span.SetTag("error", true)
-	}
-	span.Finish()
-
-	// reserve delivery agent
-	span, _ = opentracing.StartSpanFromContext(ctx, "coordinator: reserver_delivery_agent in delivery_svc")
-	req, _ = http.NewRequestWithContext(ctx,
-		"POST",
-		"http://localhost:8081/agent/reserve",
-		nil,
-	)
-	span.Tracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-	resp, err = http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Error reserving delivery agent from delivery-svc: ", err)
-		span.SetTag("error", true)
+	return n, nil
+}
+
+// orderLines builds the lines of an order request, merging the lines of the
+// same item. A request with only an item_id orders one unit of it.
+func orderLines(request dto.CreateOrderRequest) ([]models.OrderLine, error) {
+	items := request.Items
+	if request.ItemID != 0 {
+		if len(items) > 0 {
+			return nil, fmt.Errorf("item_id cannot be combined with items")
+		}
+		items = []dto.OrderLineDto{{ItemID: request.ItemID, Quantity: 1}}
 	}
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Error reserving delivery agent from delivery-svc: ", resp.StatusCode)
-		span.SetTag("error", true)
+	if len(items) == 0 {
+		return nil, fmt.Errorf("items are required")
 	}
-	var deliveryReservationDto dto.DeliveryAgentReservationDto
-	err = json.NewDecoder(resp.Body).Decode(&deliveryReservationDto)
-	if err != nil {
-		log.Println("Error decoding delivery agent reservation response: ", err)
-		span.SetTag("error", true)
-	}
-	span.Finish()
-
-	// create order
-	orderID := createOrder()
-
-	// book store item
-	span, _ = opentracing.StartSpanFromContext(ctx, "coordinator: book_item in store_svc")
-	itemBooking := dto.ItemBookingDto{
-		OrderID:       orderID,
-		ReservationID: itemReservationDto.ReservationID,
-	}
-	data, _ := json.Marshal(itemBooking)
-	toSend := bytes.NewBuffer(data)
-	req, _ = http.NewRequestWithContext(ctx,
-		"POST",
-		"http://localhost:8080/store/item/"+strconv.Itoa(itemID)+"/book",
-		toSend,
-	)
-	span.Tracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-	resp, err = http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Error booking item from store-svc: ", err)
-		span.SetTag("error", true)
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Error booking item from store-svc: ", resp.StatusCode)
-		span.SetTag("error", true)
-	}
-	span.Finish()
-
-	// book delivery agent
-	span, _ = opentracing.StartSpanFromContext(ctx, "coordinator: book_delivery_agent in delivery_svc")
-	deliveryAgentBooking := dto.DeliveryAgentBookingDto{
-		OrderID:       orderID,
-		ReservationID: deliveryReservationDto.ReservationID,
-	}
-	data, _ = json.Marshal(deliveryAgentBooking)
-	toSend = bytes.NewBuffer(data)
-	req, _ = http.NewRequestWithContext(ctx,
-		"POST",
-		"http://localhost:8081/agent/book",
-		toSend,
-	)
-	span.Tracer().Inject(
-		span.Context(),
-		opentracing.HTTPHeaders,
-		opentracing.HTTPHeadersCarrier(req.Header),
-	)
-	resp, err = http.DefaultClient.Do(req)
-	if err != nil {
-		log.Println("Error booking delivery agent from delivery-svc: ", err)
-		span.SetTag("error", true)
-	}
-	if resp.StatusCode != http.StatusOK {
-		log.Println("Error booking delivery agent from delivery-svc: ", resp.StatusCode)
-		span.SetTag("error", true)
+	var lines []models.OrderLine
+	positions := make(map[int]int, len(items))
+	for _, item := range items {
+		if item.ItemID <= 0 {
+			return nil, fmt.Errorf("every item needs an item_id")
+		}
+		if item.Quantity < 1 || item.Quantity > maxLineQuantity {
+			return nil, fmt.Errorf("quantity of item %d must be between 1 and %d",
+				item.ItemID, maxLineQuantity)
+		}
+		if i, ok := positions[item.ItemID]; ok {
+			lines[i].Quantity += item.Quantity
+			continue
+		}
+		positions[item.ItemID] = len(lines)
+		lines = append(lines, models.OrderLine{ItemID: item.ItemID, Quantity: item.Quantity})
 	}
-	span.Finish()
-
-	log.Printf("Order %s created\n", orderID)
+	return lines, nil
 }
 
-func registerRoutes(router *chi.Mux) {
-	router.Post("/order", func(w http.ResponseWriter, r *http.Request) {
-		spanCtx, _ := tracer.Extract(opentracing.HTTPHeaders,
-			opentracing.HTTPHeadersCarrier(r.Header))
-		span := tracer.StartSpan("order-svc: Create Order", ext.RPCServerOption(spanCtx))
-		defer span.Finish()
+// createOrderErrorStatus is the status answered for an order that was not
+// placed. Only a definite outcome is a 4xx, since those are kept for
+// retries with the same Idempotency-Key.
+func createOrderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, coordinator.ErrUnknownMode):
+		return http.StatusBadRequest
+	case errors.Is(err, coordinator.ErrRefused), errors.Is(err, coordinator.ErrHoldLost):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
 
-		ctx := opentracing.ContextWithSpan(r.Context(), span)
+func registerRoutes(router *chi.Mux, controller *controllers.OrderController,
+	idempotencyKeys *idempotency.Store) {
+	router.With(idempotencyKeys.Middleware).Post("/order", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
 
 		var createOrderRequest dto.CreateOrderRequest
 		err := json.NewDecoder(r.Body).Decode(&createOrderRequest)
@@ -185,30 +118,210 @@ func registerRoutes(router *chi.Mux) {
 			message := map[string]string{
 				"message": "Error decoding request body",
 			}
-			span.SetTag("error", true)
+			span.SetStatus(codes.Error, err.Error())
+			utils.Respond(w, http.StatusBadRequest, message)
+			return
+		}
+		lines, err := orderLines(createOrderRequest)
+		if err != nil {
+			message := map[string]string{
+				"message": err.Error(),
+			}
+			span.SetStatus(codes.Error, err.Error())
 			utils.Respond(w, http.StatusBadRequest, message)
 			return
 		}
-		coordinator(ctx, createOrderRequest.ItemID)
+		if createOrderRequest.CustomerTier != "" {
+			ctx = distributedTracer.WithBaggage(ctx, distributedTracer.BaggageCustomerTier,
+				createOrderRequest.CustomerTier)
+		}
+		orderID, err := orderCoordinator.CreateOrder(ctx, coordinator.Order{
+			Lines:        lines,
+			DeliveryZone: createOrderRequest.DeliveryZone,
+		}, createOrderRequest.Mode)
+		if errors.Is(err, coordinator.ErrInDoubt) {
+			message := map[string]string{
+				"message":  "Order is being completed",
+				"order_id": orderID,
+			}
+			utils.Respond(w, http.StatusAccepted, message)
+			return
+		}
+		if err != nil {
+			message := map[string]string{
+				"message": "Failed to create order",
+				"error":   err.Error(),
+			}
+			span.SetStatus(codes.Error, err.Error())
+			utils.Respond(w, createOrderErrorStatus(err), message)
+			return
+		}
 		message := map[string]string{
-			"message": "Order created",
+			"message":  "Order created",
+			"order_id": orderID,
 		}
 		utils.Respond(w, http.StatusOK, message)
 	})
+
+	router.Get("/order/{orderID}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+
+		orderID := chi.URLParam(r, "orderID")
+		span.SetAttributes(attribute.String("order.id", orderID))
+		order, err := controller.GetOrder(ctx, orderID)
+		if err != nil {
+			message := map[string]string{
+				"message": err.Error(),
+			}
+			status := http.StatusNotFound
+			if !errors.Is(err, repository.ErrOrderNotFound) {
+				span.SetStatus(codes.Error, err.Error())
+				status = http.StatusInternalServerError
+			}
+			utils.Respond(w, status, message)
+			return
+		}
+		utils.Respond(w, http.StatusOK, order)
+	})
+
+	router.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+
+		limit, err := queryInt(r, "limit", defaultOrdersLimit)
+		if err == nil && (limit == 0 || limit > maxOrdersLimit) {
+			err = fmt.Errorf("limit must be between 1 and %d", maxOrdersLimit)
+		}
+		var offset int
+		if err == nil {
+			offset, err = queryInt(r, "offset", 0)
+		}
+		if err != nil {
+			message := map[string]string{
+				"message": err.Error(),
+			}
+			span.SetStatus(codes.Error, err.Error())
+			utils.Respond(w, http.StatusBadRequest, message)
+			return
+		}
+		orders, err := controller.ListOrders(ctx, r.URL.Query().Get("state"), limit, offset)
+		if err != nil {
+			message := map[string]string{
+				"message": err.Error(),
+			}
+			span.SetStatus(codes.Error, err.Error())
+			utils.Respond(w, http.StatusInternalServerError, message)
+			return
+		}
+		utils.Respond(w, http.StatusOK, orders)
+	})
+}
+
+// initSchema runs the migrate command when one is given after the flags
+// and exits. Otherwise it applies pending migrations, unless
+// cfg.MigrateOnStart is false.
+func initSchema(cfg *config.Config) {
+	args := cfg.Args
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		log.Fatal(err)
+	}
+	migrator := &migrate.Migrator{
+		DB:         client,
+		Service:    "order-svc",
+		Migrations: migrations.Migrations,
+	}
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("unknown command %s, use migrate", args[0])
+		}
+		err := migrator.Run(context.Background(), args[1:], os.Stdout)
+		if closeErr := db.Close(); closeErr != nil {
+			slog.Error("failed to close the database", "error", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if !cfg.MigrateOnStart {
+		return
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
-	t, err := distributedTracer.GetTracer("order-svc", os.Getenv("JAEGER_AGENT_HOST"))
+	logging.Init(logging.ConfigFromEnv("order-svc"))
+	cfg, err := config.Load(config.Service{
+		Name:        "order-svc",
+		EnvPrefix:   "ORDER",
+		DefaultPort: 8082,
+		Peers: map[string]string{
+			"store-svc":    "http://localhost:8080",
+			"delivery-svc": "http://localhost:8081",
+		},
+	}, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, shutdownTracer, err := distributedTracer.GetTracer(distributedTracer.ConfigFromEnv("order-svc"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	metricsHandler, err := metrics.Init("order-svc")
 	if err != nil {
 		log.Fatal(err)
 	}
-	tracer = t
-	opentracing.SetGlobalTracer(tracer)
+
+	if err := db.InitDB(cfg.DSN, "order-svc", db.PoolConfigFromEnv(), cfg.DBTraceStatements); err != nil {
+		log.Fatal(err)
+	}
+	initSchema(cfg)
+	orderRepository := &repository.OrderRepository{}
+	orderCoordinator = &coordinator.Coordinator{
+		TransactionLog: &repository.TransactionLogRepository{},
+		Orders:         orderRepository,
+		Mode:           cfg.TransactionMode,
+		PhaseTimeout:   cfg.PhaseTimeout,
+		RecoverAfter:   cfg.RecoverAfter,
+	}
+	participantClient := &http.Client{
+		Transport: &distributedTracer.Transport{
+			Retries:      participantRetries,
+			TimingEvents: cfg.ClientTimingEvents,
+		},
+	}
+	orderCoordinator.Register(coordinator.NewStoreParticipant(cfg.Peer("store-svc"), participantClient))
+	orderCoordinator.Register(coordinator.NewDeliveryParticipant(cfg.Peer("delivery-svc"), participantClient))
+	orderCoordinator.Recover(context.Background())
+	go orderCoordinator.RunRecovery(context.Background(), cfg.RecoveryInterval)
 
 	router := chi.NewRouter()
-	registerRoutes(router)
+	router.Use(distributedTracer.Middleware)
+	router.Use(metrics.Middleware)
+	router.Handle("/metrics", metricsHandler)
+	registerRoutes(router, &controllers.OrderController{
+		OrderRepository: orderRepository,
+	}, &idempotency.Store{
+		ServiceName: "order-svc",
+		Retention:   cfg.IdempotencyRetention,
+		Lease:       cfg.IdempotencyLease,
+	})
 
-	if err := http.ListenAndServe(":8082", router); err != nil {
+	err = utils.ListenAndServe(cfg.Addr(), router)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), utils.ShutdownTimeout)
+	if shutdownErr := shutdownTracer(shutdownCtx); shutdownErr != nil {
+		slog.Error("failed to flush spans", "error", shutdownErr)
+	}
+	cancel()
+	if closeErr := db.Close(); closeErr != nil {
+		slog.Error("failed to close the database", "error", closeErr)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+)
+
+// Migrations are the schema changes of order-svc, in the order they were
+// made. Tables are created if missing so that databases created by
+// AutoMigrate can be brought under migrations.
+var Migrations = []migrate.Migration{
+	migrate.SQL(1, "create_transaction_log", `
+		create table if not exists global_transactions (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			order_id text not null,
+			item_id bigint,
+			mode text not null default '2pc',
+			state text not null
+		);
+		create index if not exists idx_global_transactions_deleted_at
+			on global_transactions (deleted_at);
+		create unique index if not exists idx_global_transactions_order_id
+			on global_transactions (order_id);
+		create index if not exists idx_global_transactions_state
+			on global_transactions (state);
+		create table if not exists transaction_participants (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			global_transaction_id bigint not null
+				constraint fk_global_transactions_participants
+				references global_transactions (id),
+			name text not null,
+			reservation_id bigint,
+			state text not null
+		);
+		create index if not exists idx_transaction_participants_deleted_at
+			on transaction_participants (deleted_at);
+		create index if not exists idx_transaction_participants_global_transaction_id
+			on transaction_participants (global_transaction_id)`, `
+		drop table if exists transaction_participants;
+		drop table if exists global_transactions`),
+	migrate.SQL(2, "create_orders", `
+		create table if not exists orders (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			order_id text not null,
+			item_id bigint,
+			mode text not null,
+			state text not null,
+			reason text
+		);
+		create index if not exists idx_orders_deleted_at on orders (deleted_at);
+		create unique index if not exists idx_orders_order_id on orders (order_id);
+		create index if not exists idx_orders_state on orders (state)`, `
+		drop table if exists orders`),
+	idempotency.Migration(3, "order-svc"),
+	migrate.SQL(4, "create_order_lines", `
+		create table if not exists order_lines (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			order_id text not null,
+			item_id bigint not null,
+			quantity integer not null
+		);
+		create index if not exists idx_order_lines_deleted_at on order_lines (deleted_at);
+		create index if not exists idx_order_lines_order_id on order_lines (order_id)`, `
+		drop table if exists order_lines`),
+	migrate.SQL(5, "add_delivery_zone", `
+		alter table orders add column if not exists delivery_zone text not null default '';
+		alter table global_transactions
+			add column if not exists delivery_zone text not null default ''`, `
+		alter table global_transactions drop column if exists delivery_zone;
+		alter table orders drop column if exists delivery_zone`),
+	idempotency.LeaseMigration(6),
+	migrate.SQL(7, "add_recovery_lease", `
+		alter table global_transactions
+			add column if not exists recovery_locked_until timestamptz`, `
+		alter table global_transactions drop column if exists recovery_locked_until`),
+}
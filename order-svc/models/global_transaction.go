@@ -0,0 +1,39 @@
+package models
+
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TransactionStarted    = "STARTED"
+	TransactionCommitting = "COMMITTING"
+	TransactionAborting   = "ABORTING"
+	TransactionCommitted  = "COMMITTED"
+	TransactionAborted    = "ABORTED"
+)
+
+const (
+	ModeTwoPhaseCommit = "2pc"
+	ModeSaga           = "saga"
+)
+
+// GlobalTransaction is the coordinator's write-ahead record of a distributed
+// transaction. Its state is written before the coordinator acts on a
+// decision so that recovery can finish the transaction after a crash. Lines
+// are those of the order, transactions logged before orders had lines only
+// have an ItemID.
+type GlobalTransaction struct {
+	gorm.Model
+	OrderID      string `gorm:"uniqueIndex;not null"`
+	ItemID       int
+	DeliveryZone string `gorm:"not null;default:''"`
+	Mode         string `gorm:"not null;default:2pc"`
+	State        string `gorm:"index;not null"`
+	Participants []TransactionParticipant
+	Lines        []OrderLine `gorm:"foreignKey:OrderID;references:OrderID"`
+	// RecoveryLockedUntil is the lease of the instance recovering the
+	// transaction, other instances leave it alone until then.
+	RecoveryLockedUntil sql.NullTime
+}
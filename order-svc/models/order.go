@@ -0,0 +1,29 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	OrderPending   = "PENDING"
+	OrderPrepared  = "PREPARED"
+	OrderCommitted = "COMMITTED"
+	OrderAborted   = "ABORTED"
+	OrderFailed    = "FAILED"
+)
+
+// Order is the customer facing record of an order. Its reservations are the
+// participants of the GlobalTransaction with the same OrderID. Orders placed
+// before orders had lines only have an ItemID. FAILED means
+// the coordinator could not finish the transaction, the periodic recovery
+// moves such an order to COMMITTED or ABORTED once the transaction has been
+// idle for RecoverAfter. An order whose transaction could not finish either
+// way, and was undone, stays FAILED with the Reason.
+type Order struct {
+	gorm.Model
+	OrderID      string `gorm:"uniqueIndex;not null"`
+	ItemID       int
+	DeliveryZone string `gorm:"not null;default:''"`
+	Mode         string `gorm:"not null"`
+	State        string `gorm:"index;not null"`
+	Reason       string
+	Lines        []OrderLine `gorm:"foreignKey:OrderID;references:OrderID"`
+}
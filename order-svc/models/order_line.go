@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// OrderLine is a quantity of one item in an order.
+type OrderLine struct {
+	gorm.Model
+	OrderID  string `gorm:"index;not null"`
+	ItemID   int    `gorm:"not null"`
+	Quantity int    `gorm:"not null"`
+}
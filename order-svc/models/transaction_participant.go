@@ -0,0 +1,20 @@
+package models
+
+import "gorm.io/gorm"
+
+const (
+	ParticipantPrepared    = "PREPARED"
+	ParticipantCommitted   = "COMMITTED"
+	ParticipantReleased    = "RELEASED"
+	ParticipantCompensated = "COMPENSATED"
+)
+
+// TransactionParticipant records a reservation that a participant holds on
+// behalf of a GlobalTransaction.
+type TransactionParticipant struct {
+	gorm.Model
+	GlobalTransactionID uint   `gorm:"index;not null"`
+	Name                string `gorm:"not null"`
+	ReservationID       int64
+	State               string `gorm:"not null"`
+}
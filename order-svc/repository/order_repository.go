@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"go.opentelemetry.io/otel/codes"
+	"gorm.io/gorm"
+)
+
+var ErrOrderNotFound = errors.New("order not found")
+
+type OrderRepository struct {
+}
+
+// Create stores a new order together with its lines.
+func (r *OrderRepository) Create(ctx context.Context, orderID string,
+	lines []models.OrderLine, deliveryZone string, mode string) error {
+	ctx, span := tracer.Start(ctx, "Create: create_order in db")
+	defer span.End()
+
+	order := models.Order{
+		OrderID:      orderID,
+		DeliveryZone: deliveryZone,
+		Mode:         mode,
+		State:        models.OrderPending,
+		Lines:        lines,
+	}
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err = client.WithContext(ctx).Create(&order).Error; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to create order %s", orderID)
+	}
+	return nil
+}
+
+func (r *OrderRepository) SetState(ctx context.Context, orderID string,
+	state string, reason string) error {
+	ctx, span := tracer.Start(ctx, "SetState: set_order_state in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Model(&models.Order{}).
+		Where("order_id = ?", orderID).
+		Updates(map[string]any{"state": state, "reason": reason}).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to set state %s on order %s", state, orderID)
+	}
+	return nil
+}
+
+func (r *OrderRepository) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	ctx, span := tracer.Start(ctx, "GetOrder: get_order in db")
+	defer span.End()
+
+	var order models.Order
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	txOut := client.WithContext(ctx).Preload("Lines").Where("order_id = ?", orderID).First(&order)
+	if txOut.Error == gorm.ErrRecordNotFound {
+		return nil, ErrOrderNotFound
+	}
+	if txOut.Error != nil {
+		span.SetStatus(codes.Error, txOut.Error.Error())
+		return nil, fmt.Errorf("failed to get order %s", orderID)
+	}
+	return &order, nil
+}
+
+// ListOrders returns orders newest first, optionally only those in state.
+func (r *OrderRepository) ListOrders(ctx context.Context, state string,
+	limit int, offset int) ([]models.Order, error) {
+	ctx, span := tracer.Start(ctx, "ListOrders: list_orders in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	query := client.WithContext(ctx).Preload("Lines").Order("id desc").Limit(limit).Offset(offset)
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	var orders []models.Order
+	if err := query.Find(&orders).Error; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to list orders")
+	}
+	return orders, nil
+}
+
+// GetReservations returns the reservation ID held by each participant of the
+// given orders, keyed by order ID and then by participant name.
+func (r *OrderRepository) GetReservations(ctx context.Context,
+	orderIDs []string) (map[string]map[string]int64, error) {
+	ctx, span := tracer.Start(ctx, "GetReservations: get_reservations in db")
+	defer span.End()
+
+	var rows []struct {
+		OrderID       string
+		Name          string
+		ReservationID int64
+	}
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	err = client.WithContext(ctx).
+		Table("transaction_participants").
+		Select("global_transactions.order_id, transaction_participants.name, transaction_participants.reservation_id").
+		Joins("join global_transactions on global_transactions.id = transaction_participants.global_transaction_id").
+		Where("global_transactions.order_id in ?", orderIDs).
+		Where("transaction_participants.deleted_at is null").
+		Scan(&rows).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to get reservations")
+	}
+	reservations := make(map[string]map[string]int64)
+	for _, row := range rows {
+		if reservations[row.OrderID] == nil {
+			reservations[row.OrderID] = make(map[string]int64)
+		}
+		reservations[row.OrderID][row.Name] = row.ReservationID
+	}
+	return reservations, nil
+}
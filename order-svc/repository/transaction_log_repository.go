@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/order-svc/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/order-svc/repository")
+
+type TransactionLogRepository struct {
+}
+
+// Begin logs a new transaction for the order orderID. The lines are those
+// already stored with the order.
+func (r *TransactionLogRepository) Begin(ctx context.Context, orderID string,
+	lines []models.OrderLine, deliveryZone string, mode string) (*models.GlobalTransaction, error) {
+	ctx, span := tracer.Start(ctx, "Begin: begin_transaction in db")
+	defer span.End()
+
+	txn := models.GlobalTransaction{
+		OrderID:      orderID,
+		DeliveryZone: deliveryZone,
+		Mode:         mode,
+		State:        models.TransactionStarted,
+	}
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if err = client.WithContext(ctx).Create(&txn).Error; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to log transaction %s", orderID)
+	}
+	txn.Lines = lines
+	return &txn, nil
+}
+
+func (r *TransactionLogRepository) AddParticipant(ctx context.Context,
+	txn *models.GlobalTransaction, name string, reservationID int64) error {
+	ctx, span := tracer.Start(ctx, "AddParticipant: add_participant in db")
+	defer span.End()
+
+	participant := models.TransactionParticipant{
+		GlobalTransactionID: txn.ID,
+		Name:                name,
+		ReservationID:       reservationID,
+		State:               models.ParticipantPrepared,
+	}
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	if err = client.WithContext(ctx).Create(&participant).Error; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to log participant %s for transaction %s", name, txn.OrderID)
+	}
+	txn.Participants = append(txn.Participants, participant)
+	return nil
+}
+
+func (r *TransactionLogRepository) SetState(ctx context.Context,
+	txn *models.GlobalTransaction, state string) error {
+	ctx, span := tracer.Start(ctx, "SetState: set_transaction_state in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Model(txn).Update("state", state).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to log state %s for transaction %s", state, txn.OrderID)
+	}
+	return nil
+}
+
+func (r *TransactionLogRepository) SetParticipantState(ctx context.Context,
+	participant *models.TransactionParticipant, state string) error {
+	ctx, span := tracer.Start(ctx, "SetParticipantState: set_participant_state in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Model(participant).Update("state", state).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to log state %s for participant %s", state, participant.Name)
+	}
+	return nil
+}
+
+// GetUnfinished returns every transaction that has not reached a final state
+// and has not been logged to since idleSince, along with its participants.
+func (r *TransactionLogRepository) GetUnfinished(ctx context.Context,
+	idleSince time.Time) ([]models.GlobalTransaction, error) {
+	ctx, span := tracer.Start(ctx, "GetUnfinished: get_unfinished_transactions in db")
+	defer span.End()
+
+	var txns []models.GlobalTransaction
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	err = client.WithContext(ctx).
+		Preload("Participants").
+		Preload("Lines").
+		Where("state in ?", []string{
+			models.TransactionStarted,
+			models.TransactionCommitting,
+			models.TransactionAborting,
+		}).
+		Where("updated_at < ?", idleSince).
+		Where(`not exists (select 1 from transaction_participants p
+			where p.global_transaction_id = global_transactions.id and p.updated_at >= ?)`, idleSince).
+		Find(&txns).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to read unfinished transactions")
+	}
+	return txns, nil
+}
+
+// Claim leases txn to the caller until lease from now, unless the lease of
+// another instance is running or txn is no longer in the state it was read
+// in. The lease is not logged as progress, so updated_at is left alone.
+func (r *TransactionLogRepository) Claim(ctx context.Context,
+	txn *models.GlobalTransaction, lease time.Duration) (bool, error) {
+	ctx, span := tracer.Start(ctx, "Claim: claim_transaction in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("order-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return false, err
+	}
+	now := time.Now()
+	txOut := client.WithContext(ctx).Model(&models.GlobalTransaction{}).
+		Where("id = ? and state = ?", txn.ID, txn.State).
+		Where("recovery_locked_until is null or recovery_locked_until < ?", now).
+		UpdateColumn("recovery_locked_until", now.Add(lease))
+	if txOut.Error != nil {
+		span.SetStatus(codes.Error, txOut.Error.Error())
+		return false, fmt.Errorf("failed to claim transaction %s", txn.OrderID)
+	}
+	return txOut.RowsAffected == 1, nil
+}
@@ -2,48 +2,263 @@ package controllers
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"log/slog"
 
+	"github.com/Roy19/distributed-transaction-2pc/store-svc/dto"
+	"github.com/Roy19/distributed-transaction-2pc/store-svc/models"
 	"github.com/Roy19/distributed-transaction-2pc/store-svc/repository"
-	"github.com/opentracing/opentracing-go"
+	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/store-svc/controllers")
+
 type StoreController struct {
 	StoreRepository *repository.StoreRepository
 }
 
 func (c *StoreController) GetItem(ctx context.Context, itemID int64) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "StoreController.GetItem: get_item_availability")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "StoreController.GetItem: get_item_availability")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
 
-	_, err := c.StoreRepository.GetItem(opentracing.ContextWithSpan(ctx, span),
-		itemID)
+	_, err := c.StoreRepository.GetItem(ctx, itemID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to get item from db\n")
+		slog.ErrorContext(ctx, "failed to get item from db",
+			"item_id", itemID, "error", err)
 	}
 	return err
 }
 
-func (c *StoreController) ReserveItem(ctx context.Context, itemID int64) (uint, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "StoreController.ReserveItem: reserve_item")
-	defer span.Finish()
+func (c *StoreController) ReserveItem(ctx context.Context, itemID int64, holder string) (uint, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.ReserveItem: reserve_item")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
 
-	id, err := c.StoreRepository.CreateReservation(opentracing.ContextWithSpan(ctx, span),
-		itemID)
+	id, err := c.StoreRepository.CreateReservation(ctx, itemID, holder)
 	if err != nil {
-		log.Printf("[ERROR] Failed to create a reservation on that item\n")
+		slog.ErrorContext(ctx, "failed to create a reservation on that item",
+			"item_id", itemID, "error", err)
 	}
 	return id, err
 }
 
 func (c *StoreController) BookItem(ctx context.Context, reservationID int64, orderID string) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "StoreController.BookItem: book_item")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "StoreController.BookItem: book_item")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.StoreRepository.BookItem(ctx, reservationID, orderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to book the item",
+			"reservation_id", reservationID, "error", err)
+	}
+	return err
+}
+
+func (c *StoreController) ReleaseItem(ctx context.Context, itemID int64,
+	reservationID int64, holder string) error {
+	ctx, span := tracer.Start(ctx, "StoreController.ReleaseItem: release_item")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.StoreRepository.ReleaseReservation(ctx, itemID, reservationID, holder)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release the reservation on that item",
+			"item_id", itemID, "reservation_id", reservationID, "error", err)
+	}
+	return err
+}
+
+func (c *StoreController) CancelBooking(ctx context.Context, reservationID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "StoreController.CancelBooking: cancel_booking")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.StoreRepository.CancelBooking(ctx, reservationID, orderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to cancel the booking on that item",
+			"reservation_id", reservationID, "error", err)
+	}
+	return err
+}
+
+func toItemDto(item repository.ItemStock) dto.ItemDto {
+	return dto.ItemDto{
+		ID:          item.ID,
+		Name:        item.Name,
+		SKU:         item.SKU.String,
+		Price:       item.Price,
+		Description: item.Description,
+		Available:   item.Available,
+		Stock:       item.Slots,
+		CreatedAt:   item.CreatedAt,
+		UpdatedAt:   item.UpdatedAt,
+	}
+}
+
+func toStoreItem(item dto.SaveItemDto) *models.StoreItem {
+	return &models.StoreItem{
+		Name:        item.Name,
+		SKU:         sql.NullString{String: item.SKU, Valid: item.SKU != ""},
+		Price:       item.Price,
+		Description: item.Description,
+	}
+}
+
+func (c *StoreController) ListItems(ctx context.Context, filter repository.ItemFilter,
+	limit int, offset int) ([]dto.ItemDto, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.ListItems: list_items")
+	defer span.End()
+
+	items, err := c.StoreRepository.ListItems(ctx, filter, limit, offset)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to list items from db", "error", err)
+		return nil, err
+	}
+	itemDtos := make([]dto.ItemDto, 0, len(items))
+	for _, item := range items {
+		itemDtos = append(itemDtos, toItemDto(item))
+	}
+	return itemDtos, nil
+}
+
+func (c *StoreController) GetItemDetails(ctx context.Context, itemID int64) (*dto.ItemDto, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.GetItemDetails: get_item_details")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("item.id", itemID))
+
+	item, err := c.StoreRepository.GetItemStock(ctx, itemID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to get item from db",
+			"item_id", itemID, "error", err)
+		return nil, err
+	}
+	itemDto := toItemDto(*item)
+	return &itemDto, nil
+}
+
+func (c *StoreController) CreateItem(ctx context.Context, item dto.SaveItemDto) (*dto.ItemDto, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.CreateItem: create_item")
+	defer span.End()
+
+	storeItem := toStoreItem(item)
+	if err := c.StoreRepository.CreateItem(ctx, storeItem); err != nil {
+		slog.ErrorContext(ctx, "failed to create item",
+			"item", item.Name, "sku", item.SKU, "error", err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("item.id", int64(storeItem.ID)))
+	itemDto := toItemDto(repository.ItemStock{StoreItem: *storeItem})
+	return &itemDto, nil
+}
+
+func (c *StoreController) UpdateItem(ctx context.Context, itemID int64,
+	item dto.SaveItemDto) (*dto.ItemDto, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.UpdateItem: update_item")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("item.id", itemID))
+
+	if err := c.StoreRepository.UpdateItem(ctx, itemID, toStoreItem(item)); err != nil {
+		slog.ErrorContext(ctx, "failed to update item",
+			"item_id", itemID, "error", err)
+		return nil, err
+	}
+	return c.GetItemDetails(ctx, itemID)
+}
+
+func (c *StoreController) DeleteItem(ctx context.Context, itemID int64) error {
+	ctx, span := tracer.Start(ctx, "StoreController.DeleteItem: delete_item")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("item.id", itemID))
+
+	err := c.StoreRepository.DeleteItem(ctx, itemID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to delete item",
+			"item_id", itemID, "error", err)
+	}
+	return err
+}
+
+// RestockItem adds quantity slots to the item and returns it with its new
+// stock.
+func (c *StoreController) RestockItem(ctx context.Context, itemID int64,
+	quantity int) (*dto.ItemDto, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.RestockItem: restock_item")
+	defer span.End()
+	span.SetAttributes(
+		attribute.Int64("item.id", itemID),
+		attribute.Int("restock.quantity", quantity),
+	)
+
+	if err := c.StoreRepository.Restock(ctx, itemID, quantity); err != nil {
+		slog.ErrorContext(ctx, "failed to restock item",
+			"item_id", itemID, "quantity", quantity, "error", err)
+		return nil, err
+	}
+	slog.InfoContext(ctx, "restocked item", "item_id", itemID, "quantity", quantity)
+	return c.GetItemDetails(ctx, itemID)
+}
+
+// ReserveBatch reserves every item of the batch, or none of them, and
+// returns the batch ID.
+func (c *StoreController) ReserveBatch(ctx context.Context, batch dto.ReserveBatchDto,
+	holder string) (uint, error) {
+	ctx, span := tracer.Start(ctx, "StoreController.ReserveBatch: reserve_batch")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	lines := make([]repository.ReservationLine, 0, len(batch.Items))
+	for _, item := range batch.Items {
+		lines = append(lines, repository.ReservationLine{
+			ItemID:   item.ItemID,
+			Quantity: item.Quantity,
+		})
+	}
+	id, err := c.StoreRepository.ReserveBatch(ctx, lines, holder)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to reserve the batch", "error", err)
+	}
+	return id, err
+}
+
+func (c *StoreController) BookBatch(ctx context.Context, batchID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "StoreController.BookBatch: book_batch")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.StoreRepository.BookBatch(ctx, batchID, orderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to book the batch",
+			"batch_id", batchID, "error", err)
+	}
+	return err
+}
+
+func (c *StoreController) ReleaseBatch(ctx context.Context, batchID int64, holder string) error {
+	ctx, span := tracer.Start(ctx, "StoreController.ReleaseBatch: release_batch")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
+
+	err := c.StoreRepository.ReleaseBatch(ctx, batchID, holder)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release the batch",
+			"batch_id", batchID, "error", err)
+	}
+	return err
+}
+
+func (c *StoreController) CancelBatchBooking(ctx context.Context, batchID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "StoreController.CancelBatchBooking: cancel_batch_booking")
+	defer span.End()
+	span.SetAttributes(distributedTracer.BaggageAttributes(ctx)...)
 
-	err := c.StoreRepository.BookItem(opentracing.ContextWithSpan(ctx, span),
-		reservationID, orderID)
+	err := c.StoreRepository.CancelBatchBooking(ctx, batchID, orderID)
 	if err != nil {
-		log.Printf("[ERROR] Failed to book the item")
+		slog.ErrorContext(ctx, "failed to cancel the booking of the batch",
+			"batch_id", batchID, "error", err)
 	}
 	return err
 }
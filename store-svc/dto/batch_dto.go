@@ -0,0 +1,14 @@
+package dto
+
+// ReserveBatchDto reserves slots of several items at once.
+type ReserveBatchDto struct {
+	Items []BatchItemDto `json:"items"`
+	// OrderID is the order the batch is reserved for, the Idempotency-Key
+	// of the request is used when it is empty.
+	OrderID string `json:"orderId,omitempty"`
+}
+
+type BatchItemDto struct {
+	ItemID   int64 `json:"itemId"`
+	Quantity int   `json:"quantity"`
+}
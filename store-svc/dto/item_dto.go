@@ -0,0 +1,30 @@
+package dto
+
+import "time"
+
+// ItemDto is a catalog item with its stock.
+type ItemDto struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	SKU         string `json:"sku,omitempty"`
+	Price       int64  `json:"price"`
+	Description string `json:"description"`
+	// Available is the number of slots free to be reserved.
+	Available int64 `json:"available"`
+	// Stock is the number of slots, reserved and booked ones included.
+	Stock     int64     `json:"stock"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SaveItemDto creates an item or replaces the details of one.
+type SaveItemDto struct {
+	Name        string `json:"name"`
+	SKU         string `json:"sku"`
+	Price       int64  `json:"price"`
+	Description string `json:"description"`
+}
+
+type RestockItemDto struct {
+	Quantity int `json:"quantity"`
+}
@@ -0,0 +1,7 @@
+package dto
+
+type ReleaseItemDto struct {
+	ReservationID int64 `json:"reservationId"`
+	// OrderID is the order the reservation was taken for.
+	OrderID string `json:"orderId"`
+}
@@ -0,0 +1,7 @@
+package dto
+
+type ReserveItemDto struct {
+	// OrderID is the order the item is reserved for, the Idempotency-Key of
+	// the request is used when it is empty.
+	OrderID string `json:"orderId,omitempty"`
+}
@@ -1,47 +1,508 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/Roy19/distributed-transaction-2pc/config"
 	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/fixtures"
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/logging"
+	"github.com/Roy19/distributed-transaction-2pc/metrics"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
 	"github.com/Roy19/distributed-transaction-2pc/store-svc/controllers"
 	"github.com/Roy19/distributed-transaction-2pc/store-svc/dto"
-	"github.com/Roy19/distributed-transaction-2pc/store-svc/models"
+	"github.com/Roy19/distributed-transaction-2pc/store-svc/migrations"
 	"github.com/Roy19/distributed-transaction-2pc/store-svc/repository"
+	"github.com/Roy19/distributed-transaction-2pc/sweeper"
 	distributedTracer "github.com/Roy19/distributed-transaction-2pc/tracer"
 	"github.com/Roy19/distributed-transaction-2pc/utils"
 	"github.com/go-chi/chi/v5"
-	"github.com/opentracing/opentracing-go"
-	"github.com/opentracing/opentracing-go/ext"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	tracer opentracing.Tracer
+const (
+	defaultItemsLimit = 20
+	maxItemsLimit     = 100
+	// maxRestockQuantity bounds the slots added by one restock.
+	maxRestockQuantity = 10000
 )
 
-func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
+// queryInt reads a non negative integer query parameter, returning def when
+// it is absent.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non negative integer", name)
+	}
+	return n, nil
+}
+
+// itemFilter reads the filters of GET /store/items.
+func itemFilter(r *http.Request) (repository.ItemFilter, error) {
+	query := r.URL.Query()
+	filter := repository.ItemFilter{
+		Name: query.Get("name"),
+		SKU:  query.Get("sku"),
+	}
+	if query.Get("minPrice") != "" {
+		minPrice, err := queryInt(r, "minPrice", 0)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinPrice = &minPrice
+	}
+	if query.Get("maxPrice") != "" {
+		maxPrice, err := queryInt(r, "maxPrice", 0)
+		if err != nil {
+			return filter, err
+		}
+		filter.MaxPrice = &maxPrice
+	}
+	if value := query.Get("inStock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("inStock must be true or false")
+		}
+		filter.InStock = inStock
+	}
+	return filter, nil
+}
+
+func validateItem(item dto.SaveItemDto) error {
+	if strings.TrimSpace(item.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if item.Price < 0 {
+		return fmt.Errorf("price cannot be negative")
+	}
+	return nil
+}
+
+// itemErrorStatus is the status answered for an error of the item endpoints.
+func itemErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrDuplicateSKU), errors.Is(err, repository.ErrItemReserved):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// respondItemError answers err, failing the request span on server errors.
+func respondItemError(w http.ResponseWriter, span trace.Span, err error) {
+	status := itemErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	errorMessage := map[string]any{
+		"error": err.Error(),
+	}
+	utils.Respond(w, status, errorMessage)
+}
+
+// bookErrorStatus is the status answered for an error booking a
+// reservation. A reservation that expired is 410 Gone, it can never be
+// booked and the coordinator fails the order.
+func bookErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrReservationExpired) {
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// reservationErrorStatus is the status answered for an error releasing a
+// reservation or cancelling a booking. Only a reservation or booking that
+// does not exist is a 404, which the coordinator takes as already undone.
+func reservationErrorStatus(err error) int {
+	if errors.Is(err, repository.ErrReservationNotFound) ||
+		errors.Is(err, repository.ErrBookingNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func initItemRoutes(mux *chi.Mux, controller *controllers.StoreController,
+	idempotencyKeys *idempotency.Store) {
+	mux.Get("/store/items", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+
+		limit, err := queryInt(r, "limit", defaultItemsLimit)
+		if err == nil && (limit == 0 || limit > maxItemsLimit) {
+			err = fmt.Errorf("limit must be between 1 and %d", maxItemsLimit)
+		}
+		var offset int
+		if err == nil {
+			offset, err = queryInt(r, "offset", 0)
+		}
+		var filter repository.ItemFilter
+		if err == nil {
+			filter, err = itemFilter(r)
+		}
+		if err != nil {
+			errorMessage := map[string]any{
+				"error": err.Error(),
+			}
+			span.SetStatus(codes.Error, err.Error())
+			utils.Respond(w, http.StatusBadRequest, errorMessage)
+			return
+		}
+		items, err := controller.ListItems(ctx, filter, limit, offset)
+		if err != nil {
+			respondItemError(w, span, err)
+			return
+		}
+		utils.Respond(w, http.StatusOK, items)
+	})
+
+	mux.With(idempotencyKeys.Middleware).Post("/store/items", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+
+		var item dto.SaveItemDto
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			errorMessage := map[string]any{
+				"error": "failed to unmarshal json",
+			}
+			utils.Respond(w, http.StatusBadRequest, errorMessage)
+			return
+		}
+		if err := validateItem(item); err != nil {
+			errorMessage := map[string]any{
+				"error": err.Error(),
+			}
+			utils.Respond(w, http.StatusBadRequest, errorMessage)
+			return
+		}
+		created, err := controller.CreateItem(ctx, item)
+		if err != nil {
+			respondItemError(w, span, err)
+			return
+		}
+		utils.Respond(w, http.StatusCreated, created)
+	})
+
+	mux.Route("/store/items/{itemID}", func(r chi.Router) {
+		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			item, err := controller.GetItemDetails(ctx, itemID)
+			if err != nil {
+				respondItemError(w, span, err)
+				return
+			}
+			utils.Respond(w, http.StatusOK, item)
+		})
+
+		r.Put("/", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			var item dto.SaveItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			if err := validateItem(item); err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			updated, err := controller.UpdateItem(ctx, itemID, item)
+			if err != nil {
+				respondItemError(w, span, err)
+				return
+			}
+			utils.Respond(w, http.StatusOK, updated)
+		})
+
+		r.Delete("/", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			if err := controller.DeleteItem(ctx, itemID); err != nil {
+				respondItemError(w, span, err)
+				return
+			}
+			data := map[string]any{
+				"message": "item deleted",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.With(idempotencyKeys.Middleware).Post("/restock", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID, err := strconv.ParseInt(chi.URLParam(r, "itemID"), 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			var restock dto.RestockItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&restock); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			if restock.Quantity < 1 || restock.Quantity > maxRestockQuantity {
+				errorMessage := map[string]any{
+					"error": fmt.Sprintf("quantity must be between 1 and %d", maxRestockQuantity),
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			item, err := controller.RestockItem(ctx, itemID, restock.Quantity)
+			if err != nil {
+				respondItemError(w, span, err)
+				return
+			}
+			utils.Respond(w, http.StatusOK, item)
+		})
+	})
+}
+
+// reservationHolder returns the order a reservation is taken for, orderID
+// or else the Idempotency-Key of the request. Only that order may book or
+// release the reservation afterwards.
+func reservationHolder(r *http.Request, orderID string) (string, error) {
+	if orderID != "" {
+		return orderID, nil
+	}
+	if key := r.Header.Get(idempotency.HeaderKey); key != "" {
+		return key, nil
+	}
+	return "", fmt.Errorf("an orderId or an %s header is required", idempotency.HeaderKey)
+}
+
+// validateBatch checks the lines of a batch and merges the lines of the
+// same item.
+func validateBatch(batch *dto.ReserveBatchDto) error {
+	if len(batch.Items) == 0 {
+		return fmt.Errorf("items are required")
+	}
+	quantities := make(map[int64]int, len(batch.Items))
+	items := make([]dto.BatchItemDto, 0, len(batch.Items))
+	for _, item := range batch.Items {
+		if item.ItemID <= 0 {
+			return fmt.Errorf("every item needs an itemId")
+		}
+		if item.Quantity < 1 {
+			return fmt.Errorf("quantity of item %d must be at least 1", item.ItemID)
+		}
+		if _, ok := quantities[item.ItemID]; !ok {
+			items = append(items, dto.BatchItemDto{ItemID: item.ItemID})
+		}
+		quantities[item.ItemID] += item.Quantity
+	}
+	for i := range items {
+		items[i].Quantity = quantities[items[i].ItemID]
+	}
+	batch.Items = items
+	return nil
+}
+
+// initBatchRoutes serves the reservations of several items for one order,
+// which are booked, released and cancelled as a whole.
+func initBatchRoutes(mux *chi.Mux, controller *controllers.StoreController,
+	idempotencyKeys *idempotency.Store) {
+	mux.Route("/store/batch", func(r chi.Router) {
+		r.With(idempotencyKeys.Middleware).Post("/reserve", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			var batch dto.ReserveBatchDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := validateBatch(&batch)
+			var holder string
+			if err == nil {
+				holder, err = reservationHolder(r, batch.OrderID)
+			}
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			id, err := controller.ReserveBatch(ctx, batch, holder)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, repository.ErrInsufficientStock) {
+					status = http.StatusConflict
+				}
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, status, errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "items reserved",
+				"id":      id,
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.With(idempotencyKeys.Middleware).Post("/book", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var bookBatch dto.BookItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&bookBatch); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := controller.BookBatch(ctx, bookBatch.ReservationID, bookBatch.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, bookErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "items booked",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.Post("/release", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var releaseBatch dto.ReleaseItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&releaseBatch); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := controller.ReleaseBatch(ctx, releaseBatch.ReservationID, releaseBatch.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "items released",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.Post("/unbook", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var bookBatch dto.BookItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&bookBatch); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err := controller.CancelBatchBooking(ctx, bookBatch.ReservationID, bookBatch.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "items booking cancelled",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+	})
+}
+
+func initRoutes(mux *chi.Mux, controller *controllers.StoreController,
+	idempotencyKeys *idempotency.Store) {
 	mux.Get("/status", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
 
 	mux.Route("/store/item/{itemID}", func(r chi.Router) {
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
-			spanCtx, _ := tracer.Extract(
-				opentracing.HTTPHeaders,
-				opentracing.HTTPHeadersCarrier(r.Header),
-			)
-
-			span := tracer.StartSpan("GET /store/item/{itemID}: get_item_availability",
-				ext.RPCServerOption(spanCtx),
-			)
-			defer span.Finish()
-
-			ctx := opentracing.ContextWithSpan(r.Context(), span)
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
 
 			itemID := chi.URLParam(r, "itemID")
 			itemIDAsInt, err := strconv.ParseInt(itemID, 10, 64)
@@ -49,7 +510,7 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 				errorMessage := map[string]any{
 					"error": "itemID is required",
 				}
-				span.SetTag("error", true)
+				span.SetStatus(codes.Error, err.Error())
 				utils.Respond(w, http.StatusBadRequest, errorMessage)
 				return
 			}
@@ -67,18 +528,9 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 			utils.Respond(w, http.StatusOK, data)
 		})
 
-		r.Post("/reserve", func(w http.ResponseWriter, r *http.Request) {
-			spanCtx, _ := tracer.Extract(
-				opentracing.HTTPHeaders,
-				opentracing.HTTPHeadersCarrier(r.Header),
-			)
-
-			span := tracer.StartSpan("POST /store/item/{itemID}/reserve: reserve_item",
-				ext.RPCServerOption(spanCtx),
-			)
-			defer span.Finish()
-
-			ctx := opentracing.ContextWithSpan(r.Context(), span)
+		r.With(idempotencyKeys.Middleware).Post("/reserve", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
 
 			itemID := chi.URLParam(r, "itemID")
 			itemIDAsInt, err := strconv.ParseInt(itemID, 10, 64)
@@ -86,16 +538,40 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 				errorMessage := map[string]any{
 					"error": "itemID is required",
 				}
-				span.SetTag("error", true)
+				span.SetStatus(codes.Error, err.Error())
 				utils.Respond(w, http.StatusBadRequest, errorMessage)
 				return
 			}
-			id, err := controller.ReserveItem(ctx, itemIDAsInt)
+			// the body is optional, the Idempotency-Key may name the order
+			var reserveItem dto.ReserveItemDto
+			defer r.Body.Close()
+			err = json.NewDecoder(r.Body).Decode(&reserveItem)
+			if err != nil && err != io.EOF {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			holder, err := reservationHolder(r, reserveItem.OrderID)
 			if err != nil {
 				errorMessage := map[string]any{
 					"error": err.Error(),
 				}
-				utils.Respond(w, http.StatusNotFound, errorMessage)
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			id, err := controller.ReserveItem(ctx, itemIDAsInt, holder)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, repository.ErrInsufficientStock) {
+					status = http.StatusConflict
+				}
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, status, errorMessage)
 				return
 			}
 			data := map[string]any{
@@ -105,18 +581,9 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 			utils.Respond(w, http.StatusOK, data)
 		})
 
-		r.Post("/book", func(w http.ResponseWriter, r *http.Request) {
-			spanCtx, _ := tracer.Extract(
-				opentracing.HTTPHeaders,
-				opentracing.HTTPHeadersCarrier(r.Header),
-			)
-
-			span := tracer.StartSpan("POST /store/item/{itemID}/book: book_item",
-				ext.RPCServerOption(spanCtx),
-			)
-			defer span.Finish()
-
-			ctx := opentracing.ContextWithSpan(r.Context(), span)
+		r.With(idempotencyKeys.Middleware).Post("/book", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
 
 			itemID := chi.URLParam(r, "itemID")
 			_, err := strconv.ParseInt(itemID, 10, 64)
@@ -124,7 +591,7 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 				errorMessage := map[string]any{
 					"error": "itemID is required",
 				}
-				span.SetTag("error", true)
+				span.SetStatus(codes.Error, err.Error())
 				utils.Respond(w, http.StatusBadRequest, errorMessage)
 				return
 			}
@@ -151,7 +618,7 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 				errorMessage := map[string]any{
 					"error": err.Error(),
 				}
-				utils.Respond(w, http.StatusInternalServerError, errorMessage)
+				utils.Respond(w, bookErrorStatus(err), errorMessage)
 				return
 			} else {
 				data := map[string]any{
@@ -160,35 +627,252 @@ func initRoutes(mux *chi.Mux, controller *controllers.StoreController) {
 				utils.Respond(w, http.StatusOK, data)
 			}
 		})
+
+		r.Post("/release", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID := chi.URLParam(r, "itemID")
+			itemIDAsInt, err := strconv.ParseInt(itemID, 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			var releaseItem dto.ReleaseItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&releaseItem); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err = controller.ReleaseItem(ctx, itemIDAsInt, releaseItem.ReservationID,
+				releaseItem.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "item released",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
+
+		r.Post("/unbook", func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+
+			itemID := chi.URLParam(r, "itemID")
+			_, err := strconv.ParseInt(itemID, 10, 64)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": "itemID is required",
+				}
+				span.SetStatus(codes.Error, err.Error())
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			var bookItem dto.BookItemDto
+			defer r.Body.Close()
+			if err := json.NewDecoder(r.Body).Decode(&bookItem); err != nil {
+				errorMessage := map[string]any{
+					"error": "failed to unmarshal json",
+				}
+				utils.Respond(w, http.StatusBadRequest, errorMessage)
+				return
+			}
+			err = controller.CancelBooking(ctx, bookItem.ReservationID, bookItem.OrderID)
+			if err != nil {
+				errorMessage := map[string]any{
+					"error": err.Error(),
+				}
+				utils.Respond(w, reservationErrorStatus(err), errorMessage)
+				return
+			}
+			data := map[string]any{
+				"message": "item booking cancelled",
+			}
+			utils.Respond(w, http.StatusOK, data)
+		})
 	})
 }
 
-func initDistributedTracer() opentracing.Tracer {
-	tracer, err := distributedTracer.GetTracer("store-svc", os.Getenv("JAEGER_AGENT_HOST"))
+// initDistributedTracer installs the tracer and returns its shutdown.
+func initDistributedTracer() func(ctx context.Context) error {
+	_, shutdown, err := distributedTracer.GetTracer(distributedTracer.ConfigFromEnv("store-svc"))
 	if err != nil {
 		log.Fatal("failed to initialize tracer")
 	}
-	opentracing.SetGlobalTracer(tracer)
-	return tracer
+	return shutdown
+}
+
+// shutdownTracer exports the spans still buffered before the process exits.
+func shutdownTracer(shutdown func(ctx context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), utils.ShutdownTimeout)
+	defer cancel()
+	if err := shutdown(ctx); err != nil {
+		slog.Error("failed to flush spans", "error", err)
+	}
 }
 
-func initDependencies() *controllers.StoreController {
-	store_dsn := os.Getenv("STORE_DSN")
-	db.InitDB(store_dsn, "store-svc")
-	tracer = initDistributedTracer()
-	opentracing.SetGlobalTracer(tracer)
-	db.MigrateModels("store-svc", models.StoreItem{}, models.StoreItemReservation{})
-	db.PutDummyDataStoreSvc("store-svc")
+func initMetrics(repository *repository.StoreRepository) http.Handler {
+	handler, err := metrics.Init("store-svc")
+	if err != nil {
+		log.Fatal("failed to initialize metrics")
+	}
+	err = metrics.ObserveGauge("github.com/Roy19/distributed-transaction-2pc/store-svc",
+		"store.reservations.free", "Number of store item reservations free to be taken.",
+		func(ctx context.Context, report func(value int64, attributes ...attribute.KeyValue)) error {
+			free, err := repository.CountFreeReservations(ctx)
+			if err != nil {
+				return err
+			}
+			for itemID, count := range free {
+				report(count, attribute.Int64("item.id", itemID))
+			}
+			return nil
+		})
+	if err != nil {
+		log.Fatal("failed to register the free reservations gauge")
+	}
+	return handler
+}
+
+// initSchema runs the migrate command when it is given after the flags
+// and exits. Otherwise it applies pending migrations, unless
+// cfg.MigrateOnStart is false.
+func initSchema(cfg *config.Config) {
+	args := cfg.Args
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		log.Fatal(err)
+	}
+	migrator := &migrate.Migrator{
+		DB:         client,
+		Service:    "store-svc",
+		Migrations: migrations.Migrations,
+	}
+	if len(args) > 0 && args[0] == "migrate" {
+		err := migrator.Run(context.Background(), args[1:], os.Stdout)
+		if closeErr := db.Close(); closeErr != nil {
+			slog.Error("failed to close the database", "error", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if !cfg.MigrateOnStart {
+		return
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// initFixtures runs the seed command when it is given after the flags and
+// exits, the command seeds the file it is given or the configured one.
+// Otherwise it applies the configured fixtures when seeding is enabled.
+func initFixtures(cfg *config.Config, repository *repository.StoreRepository) {
+	if len(cfg.Args) > 0 {
+		if cfg.Args[0] != "seed" {
+			log.Fatalf("unknown command %s, use migrate or seed", cfg.Args[0])
+		}
+		path := cfg.Fixtures
+		if len(cfg.Args) > 1 {
+			path = cfg.Args[1]
+		}
+		err := seed(context.Background(), repository, path)
+		if closeErr := db.Close(); closeErr != nil {
+			slog.Error("failed to close the database", "error", closeErr)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	if !cfg.Seed {
+		return
+	}
+	if err := seed(context.Background(), repository, cfg.Fixtures); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// seed applies the catalog items of the fixture file at path.
+func seed(ctx context.Context, repository *repository.StoreRepository, path string) error {
+	loaded, err := fixtures.Load(path)
+	if err != nil {
+		return err
+	}
+	for _, item := range loaded.Items {
+		added, err := repository.SeedItem(ctx, item.Name, item.Stock)
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "seeded item", "item", item.Name, "stock", item.Stock, "added", added)
+	}
+	return nil
+}
+
+func initDependencies(cfg *config.Config) *controllers.StoreController {
+	if err := db.InitDB(cfg.DSN, "store-svc", db.PoolConfigFromEnv(), cfg.DBTraceStatements); err != nil {
+		log.Fatal(err)
+	}
+	initSchema(cfg)
+	repository := &repository.StoreRepository{
+		ReservationTTL: cfg.ReservationTTL,
+	}
+	initFixtures(cfg, repository)
 	return &controllers.StoreController{
-		StoreRepository: &repository.StoreRepository{},
+		StoreRepository: repository,
 	}
 }
 
 func main() {
+	logging.Init(logging.ConfigFromEnv("store-svc"))
+	cfg, err := config.Load(config.Service{
+		Name:        "store-svc",
+		EnvPrefix:   "STORE",
+		DefaultPort: 8080,
+	}, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdown := initDistributedTracer()
 	mux := chi.NewRouter()
-	controller := initDependencies()
-	initRoutes(mux, controller)
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	mux.Use(distributedTracer.Middleware)
+	mux.Use(metrics.Middleware)
+	controller := initDependencies(cfg)
+	mux.Handle("/metrics", initMetrics(controller.StoreRepository))
+	idempotencyKeys := &idempotency.Store{
+		ServiceName: "store-svc",
+		Retention:   cfg.IdempotencyRetention,
+		Lease:       cfg.IdempotencyLease,
+	}
+	initRoutes(mux, controller, idempotencyKeys)
+	initItemRoutes(mux, controller, idempotencyKeys)
+	initBatchRoutes(mux, controller, idempotencyKeys)
+	reservationSweeper := &sweeper.Sweeper{
+		ServiceName: "store-svc",
+		Interval:    cfg.SweepInterval,
+		Sweep:       controller.StoreRepository.ReleaseExpired,
+	}
+	go reservationSweeper.Run(context.Background())
+	err = utils.ListenAndServe(cfg.Addr(), mux)
+	shutdownTracer(shutdown)
+	if closeErr := db.Close(); closeErr != nil {
+		slog.Error("failed to close the database", "error", closeErr)
+	}
+	if err != nil {
 		log.Fatal("failed to start server")
 	}
 }
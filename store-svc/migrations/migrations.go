@@ -0,0 +1,81 @@
+package migrations
+
+import (
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"github.com/Roy19/distributed-transaction-2pc/migrate"
+)
+
+// Migrations are the schema changes of store-svc, in the order they were
+// made. Tables are created if missing so that databases created by
+// AutoMigrate can be brought under migrations.
+var Migrations = []migrate.Migration{
+	migrate.SQL(1, "create_store_items_and_reservations", `
+		create table if not exists store_items (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			name text not null
+		);
+		create index if not exists idx_store_items_deleted_at on store_items (deleted_at);
+		create table if not exists store_item_reservations (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			store_item_id bigint constraint fk_store_item_reservations_store_item
+				references store_items (id),
+			is_reserved boolean,
+			current_order_id text
+		);
+		create index if not exists idx_store_item_reservations_deleted_at
+			on store_item_reservations (deleted_at)`, `
+		drop table if exists store_item_reservations;
+		drop table if exists store_items`),
+	migrate.SQL(2, "add_reservation_expiry", `
+		alter table store_item_reservations
+			add column if not exists reserved_at timestamptz,
+			add column if not exists expires_at timestamptz;
+		create index if not exists idx_store_item_reservations_expires_at
+			on store_item_reservations (expires_at)`, `
+		drop index if exists idx_store_item_reservations_expires_at;
+		alter table store_item_reservations
+			drop column if exists expires_at,
+			drop column if exists reserved_at`),
+	idempotency.Migration(3, "store-svc"),
+	migrate.SQL(4, "add_item_details", `
+		alter table store_items
+			add column if not exists sku text,
+			add column if not exists price bigint not null default 0,
+			add column if not exists description text not null default '';
+		create unique index if not exists idx_store_items_sku
+			on store_items (sku) where deleted_at is null`, `
+		drop index if exists idx_store_items_sku;
+		alter table store_items
+			drop column if exists description,
+			drop column if exists price,
+			drop column if exists sku`),
+	migrate.SQL(5, "create_reservation_batches", `
+		create table if not exists store_reservation_batches (
+			id bigserial primary key,
+			created_at timestamptz,
+			updated_at timestamptz,
+			deleted_at timestamptz,
+			slots integer not null
+		);
+		create index if not exists idx_store_reservation_batches_deleted_at
+			on store_reservation_batches (deleted_at);
+		alter table store_item_reservations
+			add column if not exists batch_id bigint
+				constraint fk_store_item_reservations_batch
+				references store_reservation_batches (id);
+		create index if not exists idx_store_item_reservations_batch_id
+			on store_item_reservations (batch_id)`, `
+		drop index if exists idx_store_item_reservations_batch_id;
+		alter table store_item_reservations drop column if exists batch_id;
+		drop table if exists store_reservation_batches`),
+	migrate.SQL(6, "add_reservation_holder", `
+		alter table store_item_reservations add column if not exists holder text`, `
+		alter table store_item_reservations drop column if exists holder`),
+	idempotency.LeaseMigration(7),
+}
@@ -1,8 +1,17 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"database/sql"
+
+	"gorm.io/gorm"
+)
 
 type StoreItem struct {
 	gorm.Model
 	Name string `gorm:"not null"`
+	// SKU is unique among the items that are not deleted.
+	SKU sql.NullString
+	// Price is in the smallest unit of the currency, such as cents.
+	Price       int64  `gorm:"not null;default:0"`
+	Description string `gorm:"not null;default:''"`
 }
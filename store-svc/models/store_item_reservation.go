@@ -12,4 +12,11 @@ type StoreItemReservation struct {
 	StoreItem      StoreItem
 	IsReserved     bool
 	CurrentOrderId sql.NullString
+	ReservedAt     sql.NullTime
+	ExpiresAt      sql.NullTime `gorm:"index"`
+	// BatchID is the StoreReservationBatch holding the reservation, if any.
+	BatchID sql.NullInt64 `gorm:"index"`
+	// Holder is the order that reserved the reservation, only it may book
+	// or release it.
+	Holder sql.NullString
 }
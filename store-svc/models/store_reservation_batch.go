@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+// StoreReservationBatch groups the reservations taken together for one
+// order, so that they are booked, released and cancelled as a whole.
+type StoreReservationBatch struct {
+	gorm.Model
+	// Slots is the number of reservations taken by the batch.
+	Slots int `gorm:"not null"`
+}
@@ -2,76 +2,733 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 
+	"github.com/Roy19/distributed-transaction-2pc/config"
 	"github.com/Roy19/distributed-transaction-2pc/db"
 	"github.com/Roy19/distributed-transaction-2pc/store-svc/models"
-	"github.com/opentracing/opentracing-go"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/store-svc/repository")
+
+// DefaultReservationTTL is used when a StoreRepository has no ReservationTTL.
+const DefaultReservationTTL = config.DefaultReservationTTL
+
 type StoreRepository struct {
+	// ReservationTTL is how long a reservation is held before the sweeper
+	// may release it.
+	ReservationTTL time.Duration
+}
+
+func (s *StoreRepository) reservationTTL() time.Duration {
+	if s.ReservationTTL <= 0 {
+		return DefaultReservationTTL
+	}
+	return s.ReservationTTL
 }
 
 func (s *StoreRepository) GetItem(ctx context.Context, itemID int64) (int64, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "GetItem: get_item_availability in db")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "GetItem: get_item_availability in db")
+	defer span.End()
 
 	var item models.StoreItem
-	txOut := db.GetDBClient("store-svc").First(&item, itemID)
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	txOut := client.WithContext(ctx).First(&item, itemID)
 	if txOut.Error == gorm.ErrRecordNotFound {
 		return 0, fmt.Errorf("item not found")
 	}
 	return int64(item.ID), nil
 }
 
-func (s *StoreRepository) CreateReservation(ctx context.Context, itemID int64) (uint, error) {
-	span, _ := opentracing.StartSpanFromContext(ctx, "CreateReservation: create_reservation in db")
-	defer span.Finish()
+// CreateReservation reserves a slot of the item for holder and returns the
+// reservation ID.
+func (s *StoreRepository) CreateReservation(ctx context.Context, itemID int64, holder string) (uint, error) {
+	ctx, span := tracer.Start(ctx, "CreateReservation: create_reservation in db")
+	defer span.End()
 
-	txn := db.GetDBClient("store-svc").Model(&models.StoreItemReservation{}).Begin()
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	txn := client.WithContext(ctx).Model(&models.StoreItemReservation{}).Begin()
 	var storeReservation models.StoreItemReservation
 	txn = txn.Raw(`select * from store_item_reservations 
 		where is_reserved = false and current_order_id is null and 
-		store_item_id = ?
+		deleted_at is null and store_item_id = ?
 		for update`, int(itemID)).Scan(&storeReservation)
-	if txn.Error != nil || txn.RowsAffected == 0 {
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return 0, fmt.Errorf("failed to find a free reservation on item")
+	}
+	if txn.RowsAffected == 0 {
 		txn.Rollback()
-		return 0, fmt.Errorf("no more reservations can be done on item")
+		return 0, fmt.Errorf("no more reservations can be done on item: %w", ErrInsufficientStock)
 	}
+	reservedAt := time.Now()
 	txn = txn.Exec(`update store_item_reservations
-			set is_reserved = true
-			where id = ?`, storeReservation.ID)
+			set is_reserved = true, reserved_at = ?, expires_at = ?, holder = ?
+			where id = ?`, reservedAt, reservedAt.Add(s.reservationTTL()), holder, storeReservation.ID)
 	if txn.Error != nil {
 		txn.Rollback()
-		span.SetTag("error", true)
+		span.SetStatus(codes.Error, txn.Error.Error())
 		return 0, fmt.Errorf("failed to set lock on store item")
 	}
 	txn.Commit()
 	return storeReservation.ID, nil
 }
 
+// BookItem books the reservation for orderID, which must be the order that
+// reserved it.
 func (c *StoreRepository) BookItem(ctx context.Context, reservationID int64, orderID string) error {
-	span, _ := opentracing.StartSpanFromContext(ctx, "BookItem: book_item in db")
-	defer span.Finish()
+	ctx, span := tracer.Start(ctx, "BookItem: book_item in db")
+	defer span.End()
 
-	txn := db.GetDBClient("store-svc").Model(&models.StoreItemReservation{}).Begin()
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Model(&models.StoreItemReservation{}).Begin()
 	var storeReservation models.StoreItemReservation
 	txn = txn.Raw(`select * from store_item_reservations 
-		where is_reserved = true and id = ?
-		for update`, uint(reservationID)).Scan(&storeReservation)
-	if txn.Error != nil || txn.RowsAffected == 0 {
+		where is_reserved = true and id = ? and holder = ?
+		for update`, uint(reservationID), orderID).Scan(&storeReservation)
+	if txn.Error != nil {
 		txn.Rollback()
-		return fmt.Errorf("no more reservations can be done on item")
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to find reservation on store item")
+	}
+	if txn.RowsAffected == 0 {
+		txn.Rollback()
+		return ErrReservationExpired
 	}
 	txn = txn.Exec(`update store_item_reservations
-			set is_reserved = false, current_order_id = ?
+			set is_reserved = false, current_order_id = ?, expires_at = null
 			where id = ?`, orderID, uint(reservationID))
 	if txn.Error != nil {
 		txn.Rollback()
-		span.SetTag("error", true)
+		span.SetStatus(codes.Error, txn.Error.Error())
 		return fmt.Errorf("failed to set lock on store item")
 	}
 	txn.Commit()
 	return nil
 }
+
+// ReleaseReservation frees a reservation of the item that holder reserved
+// and has not booked.
+func (c *StoreRepository) ReleaseReservation(ctx context.Context, itemID int64,
+	reservationID int64, holder string) error {
+	ctx, span := tracer.Start(ctx, "ReleaseReservation: release_reservation in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Model(&models.StoreItemReservation{}).Begin()
+	var storeReservation models.StoreItemReservation
+	txn = txn.Raw(`select * from store_item_reservations 
+		where is_reserved = true and current_order_id is null
+			and id = ? and store_item_id = ? and holder = ?
+		for update`, uint(reservationID), itemID, holder).Scan(&storeReservation)
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to find reservation on store item")
+	}
+	if txn.RowsAffected == 0 {
+		txn.Rollback()
+		return ErrReservationNotFound
+	}
+	txn = txn.Exec(`update store_item_reservations
+			set is_reserved = false, reserved_at = null, expires_at = null, holder = null
+			where id = ?`, uint(reservationID))
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to release lock on store item")
+	}
+	txn.Commit()
+	return nil
+}
+
+func (c *StoreRepository) CancelBooking(ctx context.Context, reservationID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "CancelBooking: cancel_booking in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Model(&models.StoreItemReservation{}).Begin()
+	var storeReservation models.StoreItemReservation
+	txn = txn.Raw(`select * from store_item_reservations 
+		where current_order_id = ? and id = ?
+		for update`, orderID, uint(reservationID)).Scan(&storeReservation)
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to find booking on store item")
+	}
+	if txn.RowsAffected == 0 {
+		txn.Rollback()
+		return ErrBookingNotFound
+	}
+	txn = txn.Exec(`update store_item_reservations
+			set is_reserved = false, current_order_id = null,
+				reserved_at = null, expires_at = null, holder = null
+			where id = ?`, uint(reservationID))
+	if txn.Error != nil {
+		txn.Rollback()
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to cancel booking on store item")
+	}
+	txn.Commit()
+	return nil
+}
+
+// ReleaseExpired frees every reservation whose hold has expired without the
+// item being booked and returns how many were freed.
+func (c *StoreRepository) ReleaseExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "ReleaseExpired: release_expired_reservations in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	txn := client.WithContext(ctx).Exec(`update store_item_reservations
+			set is_reserved = false, reserved_at = null, expires_at = null,
+				batch_id = null, holder = null
+			where is_reserved = true and current_order_id is null and
+			expires_at < ?`, time.Now())
+	if txn.Error != nil {
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return 0, fmt.Errorf("failed to release expired reservations")
+	}
+	return txn.RowsAffected, nil
+}
+
+// CountFreeReservations returns how many reservations are free to be taken
+// for every item.
+func (c *StoreRepository) CountFreeReservations(ctx context.Context) (map[int64]int64, error) {
+	ctx, span := tracer.Start(ctx, "CountFreeReservations: count_free_reservations in db")
+	defer span.End()
+
+	var rows []struct {
+		StoreItemID int64
+		Free        int64
+	}
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	err = client.WithContext(ctx).Model(&models.StoreItemReservation{}).
+		Select("store_item_id, count(*) as free").
+		Where("is_reserved = false and current_order_id is null").
+		Group("store_item_id").
+		Scan(&rows).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to count free reservations")
+	}
+	free := make(map[int64]int64, len(rows))
+	for _, row := range rows {
+		free[row.StoreItemID] = row.Free
+	}
+	return free, nil
+}
+
+// SeedItem makes sure an item called name exists with at least stock
+// reservation slots, and returns how many slots it added. Seeding the same
+// item again adds nothing.
+func (c *StoreRepository) SeedItem(ctx context.Context, name string, stock int) (int64, error) {
+	ctx, span := tracer.Start(ctx, "SeedItem: seed_item in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	var added int64
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// instances seeding at the same time must not create the item twice
+		err := tx.Exec("select pg_advisory_xact_lock(hashtext(?))", "store_items:"+name).Error
+		if err != nil {
+			return err
+		}
+		var item models.StoreItem
+		err = tx.Where("name = ?", name).Order("id").
+			FirstOrCreate(&item, models.StoreItem{Name: name}).Error
+		if err != nil {
+			return err
+		}
+		var slots int64
+		err = tx.Model(&models.StoreItemReservation{}).
+			Where("store_item_id = ?", item.ID).Count(&slots).Error
+		if err != nil {
+			return err
+		}
+		missing := int64(stock) - slots
+		if missing <= 0 {
+			return nil
+		}
+		reservations := make([]models.StoreItemReservation, missing)
+		for i := range reservations {
+			reservations[i].StoreItemID = int(item.ID)
+		}
+		if err := tx.Create(&reservations).Error; err != nil {
+			return err
+		}
+		added = missing
+		return nil
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, fmt.Errorf("failed to seed item %s: %w", name, err)
+	}
+	return added, nil
+}
+
+var (
+	ErrItemNotFound = errors.New("item not found")
+	ErrDuplicateSKU = errors.New("another item has that sku")
+	ErrItemReserved = errors.New("item has reservations in progress")
+	// ErrInsufficientStock is returned when an item has fewer free slots
+	// than a reservation asks for.
+	ErrInsufficientStock = errors.New("not enough stock")
+	// ErrReservationNotFound is returned when there is no reservation held
+	// by the holder to release, most likely because it was released
+	// already.
+	ErrReservationNotFound = errors.New("no reservation found to release")
+	// ErrBookingNotFound is returned when there is no booking of the order
+	// to cancel, most likely because it was cancelled already.
+	ErrBookingNotFound = errors.New("no booking found to cancel")
+	// ErrReservationExpired is returned when a reservation to book is no
+	// longer held by the order, the sweeper released it once it expired.
+	ErrReservationExpired = errors.New("reservation is no longer held")
+)
+
+// ItemStock is a catalog item with the number of its reservation slots.
+type ItemStock struct {
+	models.StoreItem
+	// Available is the number of slots free to be reserved.
+	Available int64
+	// Slots is the number of slots, reserved and booked ones included.
+	Slots int64
+}
+
+// ItemFilter narrows the items returned by ListItems, zero fields match
+// every item.
+type ItemFilter struct {
+	// Name matches items whose name contains it, ignoring case.
+	Name     string
+	SKU      string
+	MinPrice *int
+	MaxPrice *int
+	// InStock only matches items with a slot free to be reserved.
+	InStock bool
+}
+
+const availableSlots = `count(store_item_reservations.id) filter (where
+	store_item_reservations.is_reserved = false and
+	store_item_reservations.current_order_id is null)`
+
+// itemsWithStock selects items together with their slot counts.
+func itemsWithStock(client *gorm.DB) *gorm.DB {
+	return client.Model(&models.StoreItem{}).
+		Select("store_items.*, " + availableSlots + " as available, count(store_item_reservations.id) as slots").
+		Joins(`left join store_item_reservations on
+			store_item_reservations.store_item_id = store_items.id and
+			store_item_reservations.deleted_at is null`).
+		Group("store_items.id")
+}
+
+// isUniqueViolation reports whether err was raised by a unique index.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// ListItems returns items in the order they were created.
+func (c *StoreRepository) ListItems(ctx context.Context, filter ItemFilter,
+	limit int, offset int) ([]ItemStock, error) {
+	ctx, span := tracer.Start(ctx, "ListItems: list_items in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	query := itemsWithStock(client.WithContext(ctx)).
+		Order("store_items.id").Limit(limit).Offset(offset)
+	if filter.Name != "" {
+		query = query.Where("store_items.name ilike ?", "%"+filter.Name+"%")
+	}
+	if filter.SKU != "" {
+		query = query.Where("store_items.sku = ?", filter.SKU)
+	}
+	if filter.MinPrice != nil {
+		query = query.Where("store_items.price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		query = query.Where("store_items.price <= ?", *filter.MaxPrice)
+	}
+	if filter.InStock {
+		query = query.Having(availableSlots + " > 0")
+	}
+	var items []ItemStock
+	if err := query.Scan(&items).Error; err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to list items")
+	}
+	return items, nil
+}
+
+// GetItemStock returns the item itemID with its slot counts.
+func (c *StoreRepository) GetItemStock(ctx context.Context, itemID int64) (*ItemStock, error) {
+	ctx, span := tracer.Start(ctx, "GetItemStock: get_item_stock in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	var items []ItemStock
+	err = itemsWithStock(client.WithContext(ctx)).
+		Where("store_items.id = ?", itemID).Scan(&items).Error
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to get item %d", itemID)
+	}
+	if len(items) == 0 {
+		return nil, ErrItemNotFound
+	}
+	return &items[0], nil
+}
+
+// CreateItem adds item to the catalog, without any slot.
+func (c *StoreRepository) CreateItem(ctx context.Context, item *models.StoreItem) error {
+	ctx, span := tracer.Start(ctx, "CreateItem: create_item in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Create(item).Error
+	if isUniqueViolation(err) {
+		return ErrDuplicateSKU
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to create item %s", item.Name)
+	}
+	return nil
+}
+
+// UpdateItem replaces the name, sku, price and description of the item
+// itemID with those of item.
+func (c *StoreRepository) UpdateItem(ctx context.Context, itemID int64, item *models.StoreItem) error {
+	ctx, span := tracer.Start(ctx, "UpdateItem: update_item in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Model(&models.StoreItem{}).
+		Where("id = ?", itemID).
+		Select("name", "sku", "price", "description").
+		Updates(item)
+	if isUniqueViolation(txn.Error) {
+		return ErrDuplicateSKU
+	}
+	if txn.Error != nil {
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to update item %d", itemID)
+	}
+	if txn.RowsAffected == 0 {
+		return ErrItemNotFound
+	}
+	return nil
+}
+
+// DeleteItem removes the item itemID from the catalog together with its
+// free slots. Booked slots are kept for the orders holding them, an item
+// with a reservation in progress cannot be deleted.
+func (c *StoreRepository) DeleteItem(ctx context.Context, itemID int64) error {
+	ctx, span := tracer.Start(ctx, "DeleteItem: delete_item in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var item models.StoreItem
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&item, itemID).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrItemNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// lock the free slots so that no reservation is taken meanwhile
+		var reserved int64
+		err = tx.Raw(`select count(*) from (select is_reserved from store_item_reservations
+			where store_item_id = ? and current_order_id is null and deleted_at is null
+			for update) as slots where is_reserved = true`, itemID).Scan(&reserved).Error
+		if err != nil {
+			return err
+		}
+		if reserved > 0 {
+			return ErrItemReserved
+		}
+		err = tx.Where("store_item_id = ? and current_order_id is null", itemID).
+			Delete(&models.StoreItemReservation{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&item).Error
+	})
+	if err == ErrItemNotFound || err == ErrItemReserved {
+		return err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to delete item %d", itemID)
+	}
+	return nil
+}
+
+// Restock adds quantity free slots to the item itemID.
+func (c *StoreRepository) Restock(ctx context.Context, itemID int64, quantity int) error {
+	ctx, span := tracer.Start(ctx, "Restock: restock_item in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// keep the item from being deleted while its slots are added
+		var item models.StoreItem
+		err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&item, itemID).Error
+		if err == gorm.ErrRecordNotFound {
+			return ErrItemNotFound
+		}
+		if err != nil {
+			return err
+		}
+		reservations := make([]models.StoreItemReservation, quantity)
+		for i := range reservations {
+			reservations[i].StoreItemID = int(item.ID)
+		}
+		return tx.Create(&reservations).Error
+	})
+	if err == ErrItemNotFound {
+		return err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to restock item %d", itemID)
+	}
+	return nil
+}
+
+// ReservationLine asks for Quantity reservations of an item.
+type ReservationLine struct {
+	ItemID   int64
+	Quantity int
+}
+
+// ReserveBatch reserves the quantity of every line in one transaction, so
+// either every line is reserved or none is, and returns the ID of the
+// batch holding the reservations.
+func (c *StoreRepository) ReserveBatch(ctx context.Context, lines []ReservationLine, holder string) (uint, error) {
+	ctx, span := tracer.Start(ctx, "ReserveBatch: reserve_batch in db")
+	defer span.End()
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	// lock items in the same order in every batch so batches cannot deadlock
+	lines = append([]ReservationLine(nil), lines...)
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].ItemID < lines[j].ItemID
+	})
+	slots := 0
+	for _, line := range lines {
+		slots += line.Quantity
+	}
+	span.SetAttributes(
+		attribute.Int("reservation.lines", len(lines)),
+		attribute.Int("reservation.slots", slots),
+	)
+
+	batch := models.StoreReservationBatch{Slots: slots}
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&batch).Error; err != nil {
+			return err
+		}
+		reservedAt := time.Now()
+		for _, line := range lines {
+			var ids []uint
+			err := tx.Raw(`select id from store_item_reservations
+				where is_reserved = false and current_order_id is null and
+				deleted_at is null and store_item_id = ?
+				order by id limit ?
+				for update skip locked`, line.ItemID, line.Quantity).Scan(&ids).Error
+			if err != nil {
+				return err
+			}
+			if len(ids) < line.Quantity {
+				return fmt.Errorf("%w for item %d, %d free of %d asked",
+					ErrInsufficientStock, line.ItemID, len(ids), line.Quantity)
+			}
+			err = tx.Exec(`update store_item_reservations
+				set is_reserved = true, reserved_at = ?, expires_at = ?, batch_id = ?, holder = ?
+				where id in ?`, reservedAt, reservedAt.Add(c.reservationTTL()), batch.ID, holder, ids).Error
+			if err != nil {
+				return err
+			}
+			span.AddEvent("reserved item", trace.WithAttributes(
+				attribute.Int64("item.id", line.ItemID),
+				attribute.Int("quantity", line.Quantity),
+			))
+		}
+		return nil
+	})
+	if errors.Is(err, ErrInsufficientStock) {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, err
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return 0, fmt.Errorf("failed to reserve batch")
+	}
+	span.SetAttributes(attribute.Int64("reservation.batch_id", int64(batch.ID)))
+	return batch.ID, nil
+}
+
+// BookBatch books every reservation of the batch for orderID. It fails if
+// any of them was released in the meantime.
+func (c *StoreRepository) BookBatch(ctx context.Context, batchID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "BookBatch: book_batch in db")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("reservation.batch_id", batchID))
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	err = client.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var batch models.StoreReservationBatch
+		if err := tx.First(&batch, batchID).Error; err != nil {
+			return err
+		}
+		var ids []uint
+		err := tx.Raw(`select id from store_item_reservations
+			where is_reserved = true and batch_id = ? and holder = ?
+			for update`, batchID, orderID).Scan(&ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) != batch.Slots {
+			return fmt.Errorf("%d of %d reservations are still held: %w",
+				len(ids), batch.Slots, ErrReservationExpired)
+		}
+		return tx.Exec(`update store_item_reservations
+			set is_reserved = false, current_order_id = ?, expires_at = null
+			where id in ?`, orderID, ids).Error
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return fmt.Errorf("failed to book batch %d: %w", batchID, err)
+	}
+	return nil
+}
+
+// ReleaseBatch frees every reservation of the batch that holder reserved
+// and has not booked.
+func (c *StoreRepository) ReleaseBatch(ctx context.Context, batchID int64, holder string) error {
+	ctx, span := tracer.Start(ctx, "ReleaseBatch: release_batch in db")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("reservation.batch_id", batchID))
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Exec(`update store_item_reservations
+			set is_reserved = false, reserved_at = null, expires_at = null,
+				batch_id = null, holder = null
+			where is_reserved = true and current_order_id is null and
+			batch_id = ? and holder = ?`, batchID, holder)
+	if txn.Error != nil {
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to release batch %d", batchID)
+	}
+	if txn.RowsAffected == 0 {
+		return ErrReservationNotFound
+	}
+	return nil
+}
+
+// CancelBatchBooking frees every reservation of the batch booked for
+// orderID.
+func (c *StoreRepository) CancelBatchBooking(ctx context.Context, batchID int64, orderID string) error {
+	ctx, span := tracer.Start(ctx, "CancelBatchBooking: cancel_batch_booking in db")
+	defer span.End()
+	span.SetAttributes(attribute.Int64("reservation.batch_id", batchID))
+
+	client, err := db.GetDBClient("store-svc")
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	txn := client.WithContext(ctx).Exec(`update store_item_reservations
+			set is_reserved = false, current_order_id = null,
+				reserved_at = null, expires_at = null, batch_id = null, holder = null
+			where current_order_id = ? and batch_id = ?`, orderID, batchID)
+	if txn.Error != nil {
+		span.SetStatus(codes.Error, txn.Error.Error())
+		return fmt.Errorf("failed to cancel booking of batch %d", batchID)
+	}
+	if txn.RowsAffected == 0 {
+		return ErrBookingNotFound
+	}
+	return nil
+}
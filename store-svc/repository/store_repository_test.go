@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/Roy19/distributed-transaction-2pc/db"
+	"github.com/Roy19/distributed-transaction-2pc/db/dbtest"
+)
+
+// fakeCatalog registers a fake store-svc database holding items by name and
+// the number of reservation slots of each item.
+func fakeCatalog(t *testing.T) (*dbtest.DB, map[string]int64, map[int64]int64) {
+	fake := dbtest.New(t)
+	if err := db.Register("store-svc", fake.Gorm()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	items := make(map[string]int64)
+	slots := make(map[int64]int64)
+	fake.On(`^select pg_advisory_xact_lock`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Result{}
+	})
+	fake.On(`^SELECT \* FROM "store_items" WHERE name = \$1`, func(query dbtest.Query) dbtest.Result {
+		id, ok := items[query.Args[0].(string)]
+		if !ok {
+			return dbtest.Result{Columns: []string{"id", "name"}}
+		}
+		return dbtest.Result{Columns: []string{"id", "name"}, Rows: [][]driver.Value{{id, query.Args[0]}}}
+	})
+	fake.On(`^INSERT INTO "store_items"`, func(query dbtest.Query) dbtest.Result {
+		id := int64(len(items) + 1)
+		items[query.Rows()[0]["name"].(string)] = id
+		return dbtest.Rows("id", id)
+	})
+	fake.On(`^SELECT count\(\*\) FROM "store_item_reservations" WHERE store_item_id = \$1`, func(query dbtest.Query) dbtest.Result {
+		return dbtest.Rows("count", slots[query.Args[0].(int64)])
+	})
+	fake.On(`^INSERT INTO "store_item_reservations"`, func(query dbtest.Query) dbtest.Result {
+		result := dbtest.Result{Columns: []string{"id"}}
+		for _, row := range query.Rows() {
+			slots[row["store_item_id"].(int64)]++
+			result.Rows = append(result.Rows, []driver.Value{int64(len(result.Rows) + 1)})
+		}
+		return result
+	})
+	return fake, items, slots
+}
+
+func TestSeedItem(t *testing.T) {
+	fake, items, slots := fakeCatalog(t)
+	repository := &StoreRepository{}
+	ctx := context.Background()
+
+	added, err := repository.SeedItem(ctx, "Pixel 7", 3)
+	if err != nil {
+		t.Fatalf("SeedItem failed: %v", err)
+	}
+	if added != 3 || slots[items["Pixel 7"]] != 3 {
+		t.Errorf("first seed added %d slots, the item has %d, want 3", added, slots[items["Pixel 7"]])
+	}
+
+	// seeding again finds the item and its slots and adds nothing
+	fake.Reset()
+	added, err = repository.SeedItem(ctx, "Pixel 7", 3)
+	if err != nil {
+		t.Fatalf("SeedItem again failed: %v", err)
+	}
+	if added != 0 || len(items) != 1 || slots[items["Pixel 7"]] != 3 {
+		t.Errorf("second seed added %d slots, %d items exist, want nothing added", added, len(items))
+	}
+	if inserts := fake.Matching(`^INSERT`); len(inserts) != 0 {
+		t.Errorf("second seed inserted %q", inserts)
+	}
+
+	// a larger stock only adds the missing slots
+	added, err = repository.SeedItem(ctx, "Pixel 7", 5)
+	if err != nil {
+		t.Fatalf("SeedItem with more stock failed: %v", err)
+	}
+	if added != 2 || slots[items["Pixel 7"]] != 5 {
+		t.Errorf("seeding more stock added %d slots, the item has %d, want 2 and 5", added, slots[items["Pixel 7"]])
+	}
+}
+
+func TestSeedItemLocksTheItem(t *testing.T) {
+	fake, _, _ := fakeCatalog(t)
+	if _, err := (&StoreRepository{}).SeedItem(context.Background(), "Pixel 7", 1); err != nil {
+		t.Fatalf("SeedItem failed: %v", err)
+	}
+	log := fake.Log()
+	if len(log) < 2 || log[0] != dbtest.Begin || log[1] != "select pg_advisory_xact_lock(hashtext($1))" {
+		t.Errorf("SeedItem should take the lock of the item first, ran %q", log)
+	}
+}
+
+func TestReleaseReservationReportsMissingReservations(t *testing.T) {
+	tests := []struct {
+		name         string
+		answer       dbtest.Result
+		wantNotFound bool
+	}{
+		{name: "not held", answer: dbtest.Result{Columns: []string{"id"}}, wantNotFound: true},
+		{name: "database down", answer: dbtest.Result{Err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _, _ := fakeCatalog(t)
+			fake.On(`^select \* from store_item_reservations`, func(query dbtest.Query) dbtest.Result {
+				return tt.answer
+			})
+			fake.On(`^update store_item_reservations`, func(query dbtest.Query) dbtest.Result {
+				if tt.answer.Err != nil {
+					return tt.answer
+				}
+				return dbtest.Result{}
+			})
+			repository := &StoreRepository{}
+			ctx := context.Background()
+
+			err := repository.ReleaseReservation(ctx, 1, 7, "order-1")
+			if errors.Is(err, ErrReservationNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("ReleaseReservation = %v, want not found %t", err, tt.wantNotFound)
+			}
+			err = repository.ReleaseBatch(ctx, 3, "order-1")
+			if errors.Is(err, ErrReservationNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("ReleaseBatch = %v, want not found %t", err, tt.wantNotFound)
+			}
+		})
+	}
+}
+
+func TestCancelBookingReportsMissingBookings(t *testing.T) {
+	tests := []struct {
+		name         string
+		answer       dbtest.Result
+		wantNotFound bool
+	}{
+		{name: "not booked", answer: dbtest.Result{Columns: []string{"id"}}, wantNotFound: true},
+		{name: "database down", answer: dbtest.Result{Err: errors.New("connection refused")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake, _, _ := fakeCatalog(t)
+			fake.On(`^select \* from store_item_reservations`, func(query dbtest.Query) dbtest.Result {
+				return tt.answer
+			})
+			fake.On(`^update store_item_reservations`, func(query dbtest.Query) dbtest.Result {
+				if tt.answer.Err != nil {
+					return tt.answer
+				}
+				return dbtest.Result{}
+			})
+			repository := &StoreRepository{}
+			ctx := context.Background()
+
+			err := repository.CancelBooking(ctx, 7, "order-1")
+			if errors.Is(err, ErrBookingNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("CancelBooking = %v, want not found %t", err, tt.wantNotFound)
+			}
+			err = repository.CancelBatchBooking(ctx, 3, "order-1")
+			if errors.Is(err, ErrBookingNotFound) != tt.wantNotFound || err == nil {
+				t.Errorf("CancelBatchBooking = %v, want not found %t", err, tt.wantNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package sweeper
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	tracer = otel.Tracer("github.com/Roy19/distributed-transaction-2pc/sweeper")
+	meter  = otel.Meter("github.com/Roy19/distributed-transaction-2pc/sweeper")
+)
+
+var reclaimedCounter metric.Int64Counter
+
+func init() {
+	var err error
+	reclaimedCounter, err = meter.Int64Counter("reservations.reclaimed",
+		metric.WithDescription("Number of expired reservations released by the sweeper."),
+		metric.WithUnit("{reservation}"))
+	if err != nil {
+		slog.Error("failed to create the reclaimed reservations counter", "error", err)
+	}
+}
+
+// DefaultInterval is used when a Sweeper has no Interval.
+const DefaultInterval = 10 * time.Second
+
+// Sweeper periodically releases reservations whose hold has expired. Every
+// sweep is traced as its own root span.
+type Sweeper struct {
+	// ServiceName prefixes the span of every sweep.
+	ServiceName string
+	Interval    time.Duration
+	// Sweep releases expired reservations and returns how many it released.
+	Sweep func(ctx context.Context) (int64, error)
+
+	reclaimed atomic.Int64
+}
+
+// Reclaimed returns how many reservations have been released since start.
+func (s *Sweeper) Reclaimed() int64 {
+	return s.reclaimed.Load()
+}
+
+func (s *Sweeper) sweep(ctx context.Context) {
+	ctx, span := tracer.Start(ctx,
+		s.ServiceName+": sweep_expired_reservations")
+	defer span.End()
+
+	released, err := s.Sweep(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release expired reservations", "error", err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	total := s.reclaimed.Add(released)
+	reclaimedCounter.Add(ctx, released)
+	span.SetAttributes(
+		attribute.Int64("reservations.released", released),
+		attribute.Int64("reservations.reclaimed_total", total),
+	)
+	if released > 0 {
+		slog.InfoContext(ctx, "released expired reservations", "released", released)
+	}
+}
+
+// Run sweeps every Interval until ctx is done.
+func (s *Sweeper) Run(ctx context.Context) {
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
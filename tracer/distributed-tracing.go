@@ -1,25 +1,141 @@
 package tracer
 
 import (
-	"github.com/opentracing/opentracing-go"
-	"github.com/uber/jaeger-client-go"
-	"github.com/uber/jaeger-client-go/config"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterOTLPGRPC = "otlp-grpc"
+	ExporterOTLPHTTP = "otlp-http"
+	ExporterStdout   = "stdout"
+	ExporterNone     = "none"
 )
 
-func GetTracer(serviceName string, agentHostPort string) (opentracing.Tracer, error) {
-	cfg := &config.Configuration{
-		ServiceName: serviceName,
+// Config describes the TracerProvider of a service. The OTLP exporters are
+// further configured by the standard OTEL_EXPORTER_OTLP_* variables, such as
+// OTEL_EXPORTER_OTLP_ENDPOINT.
+type Config struct {
+	ServiceName    string
+	ServiceVersion string
+	InstanceID     string
+	// Exporter is one of the Exporter* names, it defaults to otlp-grpc.
+	Exporter string
+	// SpanExporter, when set, is used instead of Exporter.
+	SpanExporter sdktrace.SpanExporter
+	// Propagators are the Propagator* names combined to carry context
+	// across services, DefaultPropagators when empty.
+	Propagators []string
+	// Sampler is one of the Sampler* names, SamplerParentBased when empty.
+	// SamplerArg is the ratio of SamplerRatio or the traces per second of
+	// SamplerRateLimited.
+	Sampler    string
+	SamplerArg string
+	// LogSpans logs every sampled span as it ends, whatever the exporter.
+	LogSpans bool
+}
+
+// ConfigFromEnv builds the Config of serviceName from SERVICE_VERSION,
+// SERVICE_INSTANCE_ID, TRACES_EXPORTER, the comma separated
+// OTEL_PROPAGATORS, OTEL_TRACES_SAMPLER, OTEL_TRACES_SAMPLER_ARG and
+// TRACES_LOG_SPANS. The instance ID defaults to the host name and process ID.
+func ConfigFromEnv(serviceName string) Config {
+	instanceID := os.Getenv("SERVICE_INSTANCE_ID")
+	if instanceID == "" {
+		hostname, _ := os.Hostname()
+		instanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	var propagators []string
+	if value := os.Getenv("OTEL_PROPAGATORS"); value != "" {
+		propagators = strings.Split(value, ",")
+	}
+	return Config{
+		ServiceName:    serviceName,
+		ServiceVersion: os.Getenv("SERVICE_VERSION"),
+		InstanceID:     instanceID,
+		Exporter:       os.Getenv("TRACES_EXPORTER"),
+		Propagators:    propagators,
+		Sampler:        os.Getenv("OTEL_TRACES_SAMPLER"),
+		SamplerArg:     os.Getenv("OTEL_TRACES_SAMPLER_ARG"),
+		LogSpans:       os.Getenv("TRACES_LOG_SPANS") == "true",
+	}
+}
+
+func newExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	if cfg.SpanExporter != nil {
+		return cfg.SpanExporter, nil
+	}
+	switch cfg.Exporter {
+	case "", ExporterOTLPGRPC:
+		return otlptracegrpc.New(ctx)
+	case ExporterOTLPHTTP:
+		return otlptracehttp.New(ctx)
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case ExporterNone:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown traces exporter %s", cfg.Exporter)
+}
 
-		Sampler: &config.SamplerConfig{
-			Type:  "const",
-			Param: 1,
-		},
+// GetTracer installs a TracerProvider and propagator for the service
+// described by cfg as the global ones and returns a tracer from the provider,
+// along with the shutdown of the provider. The shutdown exports the spans
+// still buffered, it must be called before the process exits.
+func GetTracer(cfg Config) (trace.Tracer, func(ctx context.Context) error, error) {
+	ctx := context.Background()
+	propagator, err := newPropagator(cfg.Propagators)
+	if err != nil {
+		return nil, nil, err
+	}
+	sampler, err := newSampler(cfg.Sampler, cfg.SamplerArg)
+	if err != nil {
+		return nil, nil, err
+	}
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(cfg.ServiceVersion),
+		semconv.ServiceInstanceID(cfg.InstanceID),
+	))
+	if err != nil {
+		return nil, nil, err
+	}
 
-		Reporter: &config.ReporterConfig{
-			LogSpans:           true,
-			LocalAgentHostPort: agentHostPort,
-		},
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	}
+	if cfg.LogSpans {
+		options = append(options, sdktrace.WithSpanProcessor(logSpanProcessor{}))
 	}
-	tracer, _, err := cfg.NewTracer(config.Logger(jaeger.StdLogger))
-	return tracer, err
+	switch {
+	case exporter == nil:
+	case cfg.SpanExporter != nil:
+		// export synchronously so that spans can be inspected as soon as
+		// they end
+		options = append(options, sdktrace.WithSyncer(exporter))
+	default:
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	return provider.Tracer(cfg.ServiceName), provider.Shutdown, nil
 }
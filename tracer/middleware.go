@@ -0,0 +1,80 @@
+package tracer
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Roy19/distributed-transaction-2pc/tracer"
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	size       int64
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+// Middleware starts a server span for every request, continuing the trace
+// propagated by the caller. The span is named after the chi route pattern
+// once the request has been routed, and records the method, route, status
+// code and response size. Responses with a 5xx status mark the span as
+// failed, handlers can still mark other failures on trace.SpanFromContext.
+// Requests carrying DebugHeader are always sampled.
+func Middleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer(instrumentationName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(),
+			propagation.HeaderCarrier(r.Header))
+		if r.Header.Get(DebugHeader) != "" {
+			ctx = WithForcedSampling(ctx)
+		}
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.statusCode == 0 {
+			recorder.statusCode = http.StatusOK
+		}
+		if routeContext := chi.RouteContext(ctx); routeContext != nil {
+			if pattern := routeContext.RoutePattern(); pattern != "" {
+				span.SetName(r.Method + " " + pattern)
+				span.SetAttributes(semconv.HTTPRoute(pattern))
+			}
+		}
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(recorder.statusCode),
+			semconv.HTTPResponseBodySize(int(recorder.size)),
+		)
+		if recorder.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.statusCode))
+		}
+	})
+}
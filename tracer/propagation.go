@@ -0,0 +1,83 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/jaeger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	PropagatorTraceContext = "tracecontext"
+	PropagatorBaggage      = "baggage"
+	PropagatorJaeger       = "jaeger"
+	PropagatorNone         = "none"
+)
+
+// DefaultPropagators injects both W3C and Jaeger headers and accepts either,
+// so that services still sending uber-trace-id keep joining traces. Jaeger
+// comes first so that the W3C headers win when a request carries both.
+var DefaultPropagators = []string{PropagatorJaeger, PropagatorTraceContext, PropagatorBaggage}
+
+const (
+	BaggageOrderID      = "order.id"
+	BaggageCustomerTier = "customer.tier"
+)
+
+// newPropagator builds a composite propagator from propagator names. Every
+// propagator injects its headers and extraction is attempted by each of them
+// in order, so a later propagator wins when several headers are present.
+func newPropagator(names []string) (propagation.TextMapPropagator, error) {
+	if len(names) == 0 {
+		names = DefaultPropagators
+	}
+	var propagators []propagation.TextMapPropagator
+	for _, name := range names {
+		switch strings.TrimSpace(name) {
+		case PropagatorTraceContext:
+			propagators = append(propagators, propagation.TraceContext{})
+		case PropagatorBaggage:
+			propagators = append(propagators, propagation.Baggage{})
+		case PropagatorJaeger:
+			propagators = append(propagators, jaeger.Jaeger{})
+		case PropagatorNone:
+		default:
+			return nil, fmt.Errorf("unknown propagator %s", name)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...), nil
+}
+
+// WithBaggage returns a copy of ctx whose baggage has key set to value. The
+// baggage is left unchanged when key or value cannot be encoded.
+func WithBaggage(ctx context.Context, key string, value string) context.Context {
+	member, err := baggage.NewMemberRaw(key, value)
+	if err != nil {
+		return ctx
+	}
+	bag, err := baggage.FromContext(ctx).SetMember(member)
+	if err != nil {
+		return ctx
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+// BaggageValue returns the value of key in the baggage of ctx.
+func BaggageValue(ctx context.Context, key string) string {
+	return baggage.FromContext(ctx).Member(key).Value()
+}
+
+// BaggageAttributes returns every baggage member of ctx as a span attribute
+// prefixed with "baggage.".
+func BaggageAttributes(ctx context.Context) []attribute.KeyValue {
+	members := baggage.FromContext(ctx).Members()
+	attributes := make([]attribute.KeyValue, 0, len(members))
+	for _, member := range members {
+		attributes = append(attributes, attribute.String("baggage."+member.Key(), member.Value()))
+	}
+	return attributes
+}
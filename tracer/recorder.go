@@ -0,0 +1,215 @@
+package tracer
+
+import (
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestingT is the part of testing.TB used by the assertions.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...any)
+}
+
+// Recorder keeps every span finished by the global TracerProvider in
+// memory, so that tests can assert on what the instrumentation produced:
+//
+//	recorder, _ := tracer.NewRecorder("order-svc")
+//	// place an order that the delivery service refuses
+//	recorder.AssertSpan(t, "coordinator: prepare in delivery-svc").HasError()
+//	recorder.AssertSpan(t, "coordinator: abort").HasParent("POST /order")
+type Recorder struct {
+	exporter *tracetest.InMemoryExporter
+}
+
+// NewRecorder installs a TracerProvider for serviceName that samples every
+// span and records it in the returned Recorder.
+func NewRecorder(serviceName string) (*Recorder, error) {
+	exporter := tracetest.NewInMemoryExporter()
+	_, _, err := GetTracer(Config{
+		ServiceName:  serviceName,
+		SpanExporter: exporter,
+		Sampler:      SamplerAlways,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{exporter: exporter}, nil
+}
+
+// Spans returns the finished spans in the order they ended.
+func (r *Recorder) Spans() tracetest.SpanStubs {
+	return r.exporter.GetSpans()
+}
+
+// Reset forgets every recorded span.
+func (r *Recorder) Reset() {
+	r.exporter.Reset()
+}
+
+// Find returns the first finished span called name.
+func (r *Recorder) Find(name string) (tracetest.SpanStub, bool) {
+	for _, span := range r.Spans() {
+		if span.Name == name {
+			return span, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// Parent returns the recorded parent of span.
+func (r *Recorder) Parent(span tracetest.SpanStub) (tracetest.SpanStub, bool) {
+	if !span.Parent.IsValid() {
+		return tracetest.SpanStub{}, false
+	}
+	for _, candidate := range r.Spans() {
+		if candidate.SpanContext.Equal(span.Parent) {
+			return candidate, true
+		}
+	}
+	return tracetest.SpanStub{}, false
+}
+
+// Children returns the recorded children of span.
+func (r *Recorder) Children(span tracetest.SpanStub) []tracetest.SpanStub {
+	var children []tracetest.SpanStub
+	for _, candidate := range r.Spans() {
+		if candidate.Parent.SpanID() == span.SpanContext.SpanID() &&
+			candidate.Parent.TraceID() == span.SpanContext.TraceID() {
+			children = append(children, candidate)
+		}
+	}
+	return children
+}
+
+// Tree renders the recorded spans as an indented tree, one span per line
+// with an "(error)" suffix on failed spans. Spans whose parent was not
+// recorded are roots.
+func (r *Recorder) Tree() string {
+	recorded := make(map[trace.SpanID]bool)
+	for _, span := range r.Spans() {
+		recorded[span.SpanContext.SpanID()] = true
+	}
+	var builder strings.Builder
+	var render func(span tracetest.SpanStub, depth int)
+	render = func(span tracetest.SpanStub, depth int) {
+		builder.WriteString(strings.Repeat("  ", depth) + span.Name)
+		if span.Status.Code == codes.Error {
+			builder.WriteString(" (error)")
+		}
+		builder.WriteString("\n")
+		for _, child := range r.Children(span) {
+			render(child, depth+1)
+		}
+	}
+	for _, span := range r.Spans() {
+		if !span.Parent.IsValid() || !recorded[span.Parent.SpanID()] {
+			render(span, 0)
+		}
+	}
+	return builder.String()
+}
+
+// AssertSpan reports an error on t unless a span called name was recorded,
+// and returns assertions on that span.
+func (r *Recorder) AssertSpan(t TestingT, name string) *SpanAssertion {
+	t.Helper()
+	span, ok := r.Find(name)
+	if !ok {
+		t.Errorf("no span %q was recorded, got:\n%s", name, r.Tree())
+		return &SpanAssertion{t: t, recorder: r}
+	}
+	return &SpanAssertion{t: t, recorder: r, span: &span}
+}
+
+// SpanAssertion checks a recorded span, every check reports an error on the
+// test when it fails. Checks on a span that was not found do nothing.
+type SpanAssertion struct {
+	t        TestingT
+	recorder *Recorder
+	span     *tracetest.SpanStub
+}
+
+// Span returns the span under assertion, nil when it was not found.
+func (a *SpanAssertion) Span() *tracetest.SpanStub {
+	return a.span
+}
+
+// HasParent checks that the parent of the span is called name.
+func (a *SpanAssertion) HasParent(name string) *SpanAssertion {
+	a.t.Helper()
+	if a.span == nil {
+		return a
+	}
+	parent, ok := a.recorder.Parent(*a.span)
+	if !ok || parent.Name != name {
+		a.t.Errorf("span %q should be a child of %q, got:\n%s", a.span.Name, name, a.recorder.Tree())
+	}
+	return a
+}
+
+// HasChild checks that the span has a child called name.
+func (a *SpanAssertion) HasChild(name string) *SpanAssertion {
+	a.t.Helper()
+	if a.span == nil {
+		return a
+	}
+	for _, child := range a.recorder.Children(*a.span) {
+		if child.Name == name {
+			return a
+		}
+	}
+	a.t.Errorf("span %q should have a child %q, got:\n%s", a.span.Name, name, a.recorder.Tree())
+	return a
+}
+
+// HasAttribute checks that the span has the attribute key set to value.
+func (a *SpanAssertion) HasAttribute(key string, value any) *SpanAssertion {
+	a.t.Helper()
+	if a.span == nil {
+		return a
+	}
+	for _, attr := range a.span.Attributes {
+		if string(attr.Key) == key {
+			if fmt.Sprint(attr.Value.AsInterface()) != fmt.Sprint(value) {
+				a.t.Errorf("span %q has %s = %v, want %v", a.span.Name, key,
+					attr.Value.AsInterface(), value)
+			}
+			return a
+		}
+	}
+	a.t.Errorf("span %q has no attribute %s, got %v", a.span.Name, key,
+		attributeKeys(a.span.Attributes))
+	return a
+}
+
+// HasError checks that the span was marked as failed.
+func (a *SpanAssertion) HasError() *SpanAssertion {
+	a.t.Helper()
+	if a.span != nil && a.span.Status.Code != codes.Error {
+		a.t.Errorf("span %q should have failed", a.span.Name)
+	}
+	return a
+}
+
+// HasNoError checks that the span was not marked as failed.
+func (a *SpanAssertion) HasNoError() *SpanAssertion {
+	a.t.Helper()
+	if a.span != nil && a.span.Status.Code == codes.Error {
+		a.t.Errorf("span %q failed: %s", a.span.Name, a.span.Status.Description)
+	}
+	return a
+}
+
+func attributeKeys(attributes []attribute.KeyValue) []string {
+	keys := make([]string, 0, len(attributes))
+	for _, attr := range attributes {
+		keys = append(keys, string(attr.Key))
+	}
+	return keys
+}
@@ -0,0 +1,181 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var (
+	recorderOnce sync.Once
+	recorder     *Recorder
+	recorderErr  error
+)
+
+// testRecorder returns the Recorder shared by the tests of the package,
+// emptied of the spans of earlier tests.
+func testRecorder(t *testing.T) *Recorder {
+	t.Helper()
+	recorderOnce.Do(func() {
+		recorder, recorderErr = NewRecorder("tracer-test")
+	})
+	if recorderErr != nil {
+		t.Fatalf("NewRecorder failed: %v", recorderErr)
+	}
+	recorder.Reset()
+	return recorder
+}
+
+// fakeT collects the errors reported by assertions.
+type fakeT struct {
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+// recordAbortedOrder records the spans of an order whose delivery could not
+// be reserved.
+func recordAbortedOrder() {
+	tracer := otel.Tracer("tracer-test")
+	ctx, root := tracer.Start(context.Background(), "POST /order")
+	root.SetAttributes(attribute.String("order.id", "o-1"), attribute.Int("order.lines", 2))
+
+	prepareCtx, prepare := tracer.Start(ctx, "coordinator: prepare")
+	_, store := tracer.Start(prepareCtx, "coordinator: prepare in store-svc")
+	store.End()
+	_, delivery := tracer.Start(prepareCtx, "coordinator: prepare in delivery-svc")
+	delivery.SetStatus(codes.Error, "no delivery agent is available")
+	delivery.End()
+	prepare.SetStatus(codes.Error, "no delivery agent is available")
+	prepare.End()
+
+	_, abort := tracer.Start(ctx, "coordinator: abort")
+	abort.End()
+	root.End()
+}
+
+func TestRecorderAssertions(t *testing.T) {
+	tests := []struct {
+		name       string
+		assert     func(r *Recorder, t TestingT)
+		wantErrors int
+	}{
+		{
+			name: "span tree of an aborted order",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "coordinator: prepare").
+					HasError().
+					HasParent("POST /order").
+					HasChild("coordinator: prepare in delivery-svc")
+				r.AssertSpan(t, "coordinator: abort").HasNoError().HasParent("POST /order")
+				r.AssertSpan(t, "POST /order").
+					HasAttribute("order.id", "o-1").
+					HasAttribute("order.lines", 2)
+			},
+		},
+		{
+			name: "missing span",
+			assert: func(r *Recorder, t TestingT) {
+				// checks on a missing span only report it missing
+				r.AssertSpan(t, "coordinator: commit").HasError().HasParent("POST /order")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "wrong parent",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "coordinator: abort").HasParent("coordinator: prepare")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "root has no parent",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "POST /order").HasParent("coordinator: prepare")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "missing child",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "coordinator: abort").HasChild("coordinator: abort in store-svc")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "wrong attribute value",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "POST /order").HasAttribute("order.id", "o-2")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "missing attribute",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "POST /order").HasAttribute("delivery.zone", "berlin")
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "error on a successful span",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "coordinator: abort").HasError()
+			},
+			wantErrors: 1,
+		},
+		{
+			name: "no error on a failed span",
+			assert: func(r *Recorder, t TestingT) {
+				r.AssertSpan(t, "coordinator: prepare in delivery-svc").HasNoError()
+			},
+			wantErrors: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testRecorder(t)
+			recordAbortedOrder()
+			fake := &fakeT{}
+			tt.assert(r, fake)
+			if len(fake.errors) != tt.wantErrors {
+				t.Errorf("got %d errors, want %d: %q", len(fake.errors), tt.wantErrors, fake.errors)
+			}
+		})
+	}
+}
+
+func TestRecorderTree(t *testing.T) {
+	r := testRecorder(t)
+	recordAbortedOrder()
+
+	want := `POST /order
+  coordinator: prepare (error)
+    coordinator: prepare in store-svc
+    coordinator: prepare in delivery-svc (error)
+  coordinator: abort
+`
+	if got := r.Tree(); got != want {
+		t.Errorf("Tree() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRecorderReset(t *testing.T) {
+	r := testRecorder(t)
+	recordAbortedOrder()
+	if len(r.Spans()) != 5 {
+		t.Fatalf("recorded %d spans, want 5", len(r.Spans()))
+	}
+	r.Reset()
+	if _, ok := r.Find("POST /order"); ok {
+		t.Error("Find found a span after Reset")
+	}
+}
@@ -0,0 +1,178 @@
+package tracer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sampler names, following OTEL_TRACES_SAMPLER. Any of them but
+// SamplerParentBased can be prefixed with "parentbased_" to follow the
+// sampling decision of the caller and only apply to root spans.
+const (
+	SamplerAlways      = "always_on"
+	SamplerNever       = "always_off"
+	SamplerRatio       = "traceidratio"
+	SamplerRateLimited = "ratelimited"
+	SamplerParentBased = "parentbased_always_on"
+
+	parentBasedPrefix = "parentbased_"
+)
+
+// DebugHeader forces every span of a request that carries it to be sampled,
+// whatever the configured sampler.
+const DebugHeader = "X-Trace-Debug"
+
+// DefaultRateLimit is the number of traces per second sampled by
+// SamplerRateLimited when no argument is given.
+const DefaultRateLimit = 10
+
+type forcedSamplingKey struct{}
+
+// WithForcedSampling marks every span started from ctx as sampled.
+func WithForcedSampling(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcedSamplingKey{}, true)
+}
+
+func forcedSampling(ctx context.Context) bool {
+	forced, _ := ctx.Value(forcedSamplingKey{}).(bool)
+	return forced
+}
+
+// newSampler builds the sampler called name, arg is the ratio of
+// SamplerRatio or the traces per second of SamplerRateLimited.
+func newSampler(name string, arg string) (sdktrace.Sampler, error) {
+	if name == "" {
+		name = SamplerParentBased
+	}
+	parentBased := strings.HasPrefix(name, parentBasedPrefix)
+	var sampler sdktrace.Sampler
+	switch strings.TrimPrefix(name, parentBasedPrefix) {
+	case SamplerAlways:
+		sampler = sdktrace.AlwaysSample()
+	case SamplerNever:
+		sampler = sdktrace.NeverSample()
+	case SamplerRatio:
+		ratio, err := samplerArg(arg, 1)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("sampler %s needs a ratio between 0 and 1, got %q", name, arg)
+		}
+		sampler = sdktrace.TraceIDRatioBased(ratio)
+	case SamplerRateLimited:
+		perSecond, err := samplerArg(arg, DefaultRateLimit)
+		if err != nil || perSecond <= 0 {
+			return nil, fmt.Errorf("sampler %s needs a positive number of traces per second, got %q", name, arg)
+		}
+		sampler = newRateLimitingSampler(perSecond)
+	default:
+		return nil, fmt.Errorf("unknown traces sampler %s", name)
+	}
+	if parentBased {
+		sampler = sdktrace.ParentBased(sampler)
+	}
+	return debugSampler{sampler}, nil
+}
+
+func samplerArg(arg string, def float64) (float64, error) {
+	if arg == "" {
+		return def, nil
+	}
+	return strconv.ParseFloat(arg, 64)
+}
+
+// debugSampler samples every span started from a context marked with
+// WithForcedSampling and leaves the others to its base sampler.
+type debugSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s debugSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if forcedSampling(p.ParentContext) {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.RecordAndSample,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s debugSampler) Description() string {
+	return "Debug{" + s.base.Description() + "}"
+}
+
+// rateLimitingSampler samples up to perSecond traces every second, using a
+// token bucket that holds at most one second worth of traces. Only root
+// spans take from the bucket, every other span follows its parent so that
+// a sampled trace is never cut short.
+type rateLimitingSampler struct {
+	perSecond float64
+
+	mu      sync.Mutex
+	balance float64
+	last    time.Time
+}
+
+func newRateLimitingSampler(perSecond float64) *rateLimitingSampler {
+	return &rateLimitingSampler{
+		perSecond: perSecond,
+		balance:   perSecond,
+		last:      time.Now(),
+	}
+}
+
+func (s *rateLimitingSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	result := sdktrace.SamplingResult{
+		Decision:   sdktrace.Drop,
+		Tracestate: parent.TraceState(),
+	}
+	if parent.IsValid() {
+		if parent.IsSampled() {
+			result.Decision = sdktrace.RecordAndSample
+		}
+		return result
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.balance += now.Sub(s.last).Seconds() * s.perSecond
+	if s.balance > s.perSecond {
+		s.balance = s.perSecond
+	}
+	s.last = now
+	if s.balance >= 1 {
+		s.balance--
+		result.Decision = sdktrace.RecordAndSample
+	}
+	return result
+}
+
+func (s *rateLimitingSampler) Description() string {
+	return fmt.Sprintf("RateLimited{%g}", s.perSecond)
+}
+
+// logSpanProcessor logs every sampled span as it ends.
+type logSpanProcessor struct{}
+
+func (logSpanProcessor) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {}
+
+func (logSpanProcessor) OnEnd(span sdktrace.ReadOnlySpan) {
+	slog.Info("reporting span", "trace_id", span.SpanContext().TraceID().String(),
+		"span_id", span.SpanContext().SpanID().String(), "span", span.Name(),
+		"duration", span.EndTime().Sub(span.StartTime()))
+}
+
+func (logSpanProcessor) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (logSpanProcessor) ForceFlush(ctx context.Context) error {
+	return nil
+}
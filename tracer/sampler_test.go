@@ -0,0 +1,122 @@
+package tracer
+
+import (
+	"context"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func parentContext(sampled bool) context.Context {
+	flags := trace.TraceFlags(0)
+	if sampled {
+		flags = trace.FlagsSampled
+	}
+	return trace.ContextWithRemoteSpanContext(context.Background(),
+		trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID:    trace.TraceID{1},
+			SpanID:     trace.SpanID{1},
+			TraceFlags: flags,
+			Remote:     true,
+		}))
+}
+
+func TestNewSampler(t *testing.T) {
+	root := context.Background()
+	tests := []struct {
+		name    string
+		sampler string
+		arg     string
+		ctx     context.Context
+		want    sdktrace.SamplingDecision
+		wantErr bool
+	}{
+		{name: "default samples roots", ctx: root, want: sdktrace.RecordAndSample},
+		{name: "default follows an unsampled parent", ctx: parentContext(false),
+			want: sdktrace.Drop},
+		{name: "always on", sampler: SamplerAlways, ctx: parentContext(false),
+			want: sdktrace.RecordAndSample},
+		{name: "always off", sampler: SamplerNever, ctx: root, want: sdktrace.Drop},
+		{name: "always off forced by debug", sampler: SamplerNever,
+			ctx: WithForcedSampling(root), want: sdktrace.RecordAndSample},
+		{name: "ratio of one", sampler: SamplerRatio, arg: "1", ctx: root,
+			want: sdktrace.RecordAndSample},
+		{name: "ratio of zero", sampler: SamplerRatio, arg: "0", ctx: root, want: sdktrace.Drop},
+		{name: "parent based ratio follows a sampled parent", sampler: "parentbased_traceidratio",
+			arg: "0", ctx: parentContext(true), want: sdktrace.RecordAndSample},
+		{name: "parent based ratio applies to roots", sampler: "parentbased_traceidratio",
+			arg: "0", ctx: root, want: sdktrace.Drop},
+		{name: "rate limited", sampler: SamplerRateLimited, arg: "5", ctx: root,
+			want: sdktrace.RecordAndSample},
+		{name: "ratio above one", sampler: SamplerRatio, arg: "1.5", wantErr: true},
+		{name: "ratio not a number", sampler: SamplerRatio, arg: "half", wantErr: true},
+		{name: "rate limit of zero", sampler: SamplerRateLimited, arg: "0", wantErr: true},
+		{name: "unknown sampler", sampler: "sometimes", wantErr: true},
+		{name: "parent based unknown sampler", sampler: "parentbased_sometimes", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sampler, err := newSampler(tt.sampler, tt.arg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newSampler(%q, %q) = %s, want an error",
+						tt.sampler, tt.arg, sampler.Description())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newSampler(%q, %q) failed: %v", tt.sampler, tt.arg, err)
+			}
+			result := sampler.ShouldSample(sdktrace.SamplingParameters{
+				ParentContext: tt.ctx,
+				TraceID:       trace.TraceID{1},
+				Name:          "span",
+			})
+			if result.Decision != tt.want {
+				t.Errorf("decision = %v, want %v", result.Decision, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitingSampler(t *testing.T) {
+	sampler := newRateLimitingSampler(2)
+	params := sdktrace.SamplingParameters{ParentContext: context.Background()}
+	var sampled int
+	for i := 0; i < 5; i++ {
+		if sampler.ShouldSample(params).Decision == sdktrace.RecordAndSample {
+			sampled++
+		}
+	}
+	if sampled != 2 {
+		t.Errorf("sampled %d of 5 traces in a burst, want 2", sampled)
+	}
+}
+
+func TestRateLimitingSamplerFollowsParents(t *testing.T) {
+	sampler := newRateLimitingSampler(1)
+	root := sdktrace.SamplingParameters{ParentContext: context.Background()}
+	if sampler.ShouldSample(root).Decision != sdktrace.RecordAndSample {
+		t.Fatal("the first root was dropped, want it sampled")
+	}
+
+	// the bucket is empty, children still follow their parent
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want sdktrace.SamplingDecision
+	}{
+		{name: "sampled parent", ctx: parentContext(true), want: sdktrace.RecordAndSample},
+		{name: "unsampled parent", ctx: parentContext(false), want: sdktrace.Drop},
+		{name: "root", ctx: context.Background(), want: sdktrace.Drop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sampler.ShouldSample(sdktrace.SamplingParameters{ParentContext: tt.ctx})
+			if result.Decision != tt.want {
+				t.Errorf("decision = %v, want %v", result.Decision, tt.want)
+			}
+		})
+	}
+}
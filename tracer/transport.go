@@ -0,0 +1,168 @@
+package tracer
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+
+	"github.com/Roy19/distributed-transaction-2pc/idempotency"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryBackoff is multiplied by the attempt number between retries.
+const retryBackoff = 100 * time.Millisecond
+
+// Transport is an http.RoundTripper that traces every attempt of a request
+// as a client span and propagates the span's context to the server,
+// including a forced sampling decision.
+type Transport struct {
+	// Base performs the requests, http.DefaultTransport when nil.
+	Base http.RoundTripper
+	// Retries is how many times a request that is safe to repeat is retried
+	// after a transport error or a 502, 503 or 504. GET, HEAD and OPTIONS
+	// requests and those carrying an Idempotency-Key are safe to repeat.
+	Retries int
+	// TimingEvents adds DNS, connect, TLS and first byte events to the span
+	// of every attempt.
+	TimingEvents bool
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return req.Header.Get(idempotency.HeaderKey) != "" && (req.Body == nil || req.GetBody != nil)
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	retries := 0
+	if canRetry(req) {
+		retries = t.Retries
+	}
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			attemptReq = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		resp, err := t.roundTrip(attemptReq, attempt)
+		if attempt >= retries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(time.Duration(attempt+1) * retryBackoff):
+		}
+	}
+}
+
+func (t *Transport) roundTrip(req *http.Request, attempt int) (*http.Response, error) {
+	attributes := []attribute.KeyValue{
+		semconv.HTTPRequestMethodKey.String(req.Method),
+		semconv.URLFull(req.URL.String()),
+		semconv.ServerAddress(req.URL.Hostname()),
+	}
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attributes = append(attributes, semconv.ServerPort(port))
+	}
+	if attempt > 0 {
+		attributes = append(attributes, semconv.HTTPRequestResendCount(attempt))
+	}
+	ctx, span := otel.Tracer(instrumentationName).Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attributes...))
+	defer span.End()
+
+	if t.TimingEvents {
+		ctx = httptrace.WithClientTrace(ctx, clientTrace(span))
+	}
+	// RoundTrip must not modify the caller's request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	// services that do not follow the caller's decision still sample a
+	// forced trace
+	if forcedSampling(ctx) {
+		req.Header.Set(DebugHeader, "1")
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// clientTrace records the connection timing of a request as span events.
+func clientTrace(span trace.Span) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(info httptrace.DNSStartInfo) {
+			span.AddEvent("dns.start", trace.WithAttributes(attribute.String("dns.host", info.Host)))
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			span.AddEvent("dns.done", trace.WithAttributes(attribute.Bool("dns.error", info.Err != nil)))
+		},
+		ConnectStart: func(network, addr string) {
+			span.AddEvent("connect.start", trace.WithAttributes(attribute.String("net.peer.address", addr)))
+		},
+		ConnectDone: func(network, addr string, err error) {
+			span.AddEvent("connect.done", trace.WithAttributes(attribute.Bool("connect.error", err != nil)))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			span.AddEvent("connection.acquired", trace.WithAttributes(attribute.Bool("connection.reused", info.Reused)))
+		},
+		TLSHandshakeStart: func() {
+			span.AddEvent("tls.start")
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			span.AddEvent("tls.done", trace.WithAttributes(attribute.Bool("tls.error", err != nil)))
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			span.AddEvent("request.written")
+		},
+		GotFirstResponseByte: func() {
+			span.AddEvent("response.first_byte")
+		},
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DurationFromEnv parses the environment variable name as a time.Duration,
+// falling back to def when it is unset or invalid.
+func DurationFromEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Error("invalid duration, using the default",
+			"variable", name, "value", value, "default", def)
+		return def
+	}
+	return d
+}
+
+// IntFromEnv parses the environment variable name as an int, falling back
+// to def when it is unset or invalid.
+func IntFromEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Error("invalid number, using the default",
+			"variable", name, "value", value, "default", def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownTimeout bounds how long ListenAndServe waits for requests in
+// flight once the process is asked to stop.
+const ShutdownTimeout = 10 * time.Second
+
+// ListenAndServe serves handler on addr until the process is interrupted or
+// terminated, then stops accepting requests and waits for the ones in
+// flight before returning.
+func ListenAndServe(addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}